@@ -0,0 +1,195 @@
+/*
+   Zabbix -> NetBox synchronization tool
+   Copyright (C) 2025  SUSE LLC <georg.pfuetzenreuter@suse.com>
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+type auditRunKey struct{}
+type auditHostKey struct{}
+
+type auditRecord struct {
+	Time   time.Time         `json:"time"`
+	Run    string            `json:"run"`
+	Host   string            `json:"host,omitempty"`
+	HostID string            `json:"hostid,omitempty"`
+	Items  map[string]string `json:"items,omitempty"`
+	Method string            `json:"method"`
+	Path   string            `json:"path"`
+	// only set for created objects, the path contains the ID of existing objects
+	ID      int32           `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Status  int             `json:"status,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+type auditLog struct {
+	file *os.File
+	// serializes writes, a channel is used as the sync package name is taken by sync()
+	lock chan struct{}
+}
+
+func openAuditLog(path string) (*auditLog, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open audit log: %s", err)
+	}
+
+	return &auditLog{
+		file: file,
+		lock: make(chan struct{}, 1),
+	}, nil
+}
+
+func (a *auditLog) write(record auditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	a.lock <- struct{}{}
+	defer func() { <-a.lock }()
+
+	// a single write call per record keeps lines intact if multiple processes append to the same file
+	_, err = a.file.Write(append(line, '\n'))
+	if err == nil {
+		err = a.file.Sync()
+	}
+
+	return err
+}
+
+func (a *auditLog) close() {
+	err := a.file.Close()
+	if err != nil {
+		Warn("Closing audit log failed: %s", err)
+	}
+}
+
+func newRunID() string {
+	buffer := make([]byte, 8)
+	rand.Read(buffer)
+
+	return hex.EncodeToString(buffer)
+}
+
+func withAuditRun(ctx context.Context, run string) context.Context {
+	return context.WithValue(ctx, auditRunKey{}, run)
+}
+
+func withAuditHost(ctx context.Context, host *zabbixHostData) context.Context {
+	return context.WithValue(ctx, auditHostKey{}, host)
+}
+
+// records every request changing NetBox, placed above the retry transport to record only the final result
+type auditTransport struct {
+	base http.RoundTripper
+	log  *auditLog
+}
+
+func (t *auditTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if request.Method == http.MethodGet || request.Method == http.MethodHead {
+		return t.base.RoundTrip(request)
+	}
+
+	record := auditRecord{
+		Time:   time.Now().UTC(),
+		Method: request.Method,
+		Path:   request.URL.Path,
+	}
+
+	if run, ok := request.Context().Value(auditRunKey{}).(string); ok {
+		record.Run = run
+	}
+
+	if host, ok := request.Context().Value(auditHostKey{}).(*zabbixHostData); ok {
+		record.Host = host.HostName
+		record.HostID = host.HostID
+		record.Items = make(map[string]string, len(host.Metrics))
+
+		for _, metric := range host.Metrics {
+			record.Items[metric.Key] = metric.Value
+		}
+	}
+
+	if request.GetBody != nil {
+		if body, err := request.GetBody(); err == nil {
+			payload, err := io.ReadAll(body)
+			body.Close()
+
+			if err == nil && json.Valid(payload) {
+				record.Payload = payload
+			}
+		}
+	}
+
+	response, err := t.base.RoundTrip(request)
+
+	if err != nil {
+		record.Error = err.Error()
+	} else {
+		record.Status = response.StatusCode
+
+		if request.Method == http.MethodPost {
+			record.ID = readCreatedID(response)
+		}
+	}
+
+	if aerr := t.log.write(record); aerr != nil {
+		if response != nil {
+			response.Body.Close()
+		}
+
+		// the change was made nonetheless, but no further changes should be made without being recorded
+		return nil, fmt.Errorf("Writing audit log failed after %s %s: %s", request.Method, request.URL.Path, aerr)
+	}
+
+	return response, err
+}
+
+// reads the ID of a created object while leaving the response body intact for the caller
+func readCreatedID(response *http.Response) int32 {
+	body, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	response.Body = io.NopCloser(bytes.NewReader(body))
+
+	if err != nil {
+		return 0
+	}
+
+	var object struct {
+		ID int32 `json:"id"`
+	}
+
+	if json.Unmarshal(body, &object) != nil {
+		return 0
+	}
+
+	return object.ID
+}
@@ -0,0 +1,104 @@
+/*
+   Zabbix -> NetBox synchronization tool
+   Copyright (C) 2025  SUSE LLC <georg.pfuetzenreuter@suse.com>
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// a single change to a NetBox object, recorded in dry and in wet runs
+type change struct {
+	Host   string `json:"host"`
+	Object string `json:"object"`
+	ID     int32  `json:"id,omitempty"`
+	Action string `json:"action"`
+	Field  string `json:"field,omitempty"`
+	Old    any    `json:"old,omitempty"`
+	New    any    `json:"new,omitempty"`
+	// changes expected in every run, such as refreshing the last seen time, are not counted by the safety thresholds
+	routine bool
+}
+
+func recordChange(host *zabbixHostData, c change) {
+	c.Host = host.HostName
+	host.Changes = append(host.Changes, c)
+
+	host.log.Debug("Recorded change", "object", c.Object, "id", c.ID, "action", c.Action, "field", c.Field)
+}
+
+// changes of the host without routine ones, which would otherwise make every host appear updated
+func significantChanges(host *zabbixHostData) []change {
+	changes := []change{}
+
+	for _, c := range host.Changes {
+		if !c.routine {
+			changes = append(changes, c)
+		}
+	}
+
+	return changes
+}
+
+// writes the changes as JSON Lines, one record per changed field or created object, ordered by host
+func writeChanges(w io.Writer, zh *zabbixHosts) error {
+	hosts := make([]*zabbixHostData, 0, len(*zh))
+	for _, host := range *zh {
+		hosts = append(hosts, host)
+	}
+
+	slices.SortFunc(hosts, func(a, b *zabbixHostData) int {
+		return strings.Compare(a.HostName, b.HostName)
+	})
+
+	encoder := json.NewEncoder(w)
+
+	for _, host := range hosts {
+		for _, c := range significantChanges(host) {
+			if err := encoder.Encode(c); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func writeDiff(zh *zabbixHosts, path string) {
+	var w io.Writer = os.Stdout
+
+	if path != "-" {
+		file, err := os.Create(path)
+		if err != nil {
+			Error("Creating diff file failed: %s", err)
+			return
+		}
+		defer file.Close()
+
+		w = file
+	}
+
+	err := writeChanges(w, zh)
+	if err != nil {
+		Error("Writing diff failed: %s", err)
+	}
+}
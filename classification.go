@@ -0,0 +1,93 @@
+/*
+   Zabbix -> NetBox synchronization tool
+   Copyright (C) 2025  SUSE LLC <georg.pfuetzenreuter@suse.com>
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"strings"
+)
+
+// used if the configuration does not contain any classification rules, evaluated top to bottom
+var defaultClassification = []ClassificationRule{
+	// virt-what reports "xen-dom0" on the physical Xen host
+	{Virtualization: "*xen-dom0*", Type: "physical"},
+	{Virtualization: "?*", Type: "virtual"},
+	{Manufacturer: "QEMU", Type: "virtual"},
+	{Manufacturer: "Bochs", Type: "virtual"},
+	{Manufacturer: "VMware*", Type: "virtual"},
+	{Manufacturer: "Microsoft Corporation", Model: "Virtual Machine", Type: "virtual"},
+	{Manufacturer: "Xen", Type: "virtual"},
+	{Manufacturer: "innotek GmbH", Type: "virtual"},
+	{Manufacturer: "Oracle Corporation", Model: "VirtualBox", Type: "virtual"},
+	{Manufacturer: "Nutanix", Type: "virtual"},
+	{Manufacturer: "Amazon EC2", Type: "virtual"},
+	{Manufacturer: "Google", Model: "Google Compute Engine", Type: "virtual"},
+	{Manufacturer: "OpenStack Foundation", Type: "virtual"},
+	{Model: "OpenStack*", Type: "virtual"},
+	{Model: "KVM", Type: "virtual"},
+}
+
+func convertObjType(value string) string {
+	switch strings.ToLower(value) {
+	case "virtual", "vm":
+		return "Virtual"
+	case "physical", "device":
+		return "Physical"
+	default:
+		return ""
+	}
+}
+
+func matchClassificationRule(rule ClassificationRule, host *zabbixHostData) bool {
+	if rule.Manufacturer != "" && !matchPattern(rule.Manufacturer, host.Manufacturer) {
+		return false
+	}
+
+	if rule.Model != "" && !matchPattern(rule.Model, host.Model) {
+		return false
+	}
+
+	if rule.Virtualization != "" && !matchPattern(rule.Virtualization, host.Virtualization) {
+		return false
+	}
+
+	return true
+}
+
+func classifyHost(host *zabbixHostData, rules []ClassificationRule) string {
+	if value, ok := host.Meta["type"]; ok {
+		objtype := convertObjType(value)
+		if objtype != "" {
+			host.log.Debug("Using classification from metadata", "type", value)
+
+			return objtype
+		}
+
+		host.log.Warn("Host serves invalid type in metadata, ignoring it", "type", value)
+	}
+
+	for i, rule := range rules {
+		if matchClassificationRule(rule, host) {
+			host.log.Debug("Matched classification rule", "rule", i, "manufacturer", rule.Manufacturer, "model", rule.Model, "virtualization", rule.Virtualization, "type", rule.Type)
+
+			return rule.Type
+		}
+	}
+
+	return "Physical"
+}
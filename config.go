@@ -20,19 +20,112 @@ package main
 
 import (
 	"fmt"
+	"github.com/netbox-community/go-netbox/v4"
 	"gopkg.in/yaml.v3"
+	"net"
 	"os"
+	"regexp"
+	"slices"
+	"time"
 )
 
+type ClassificationRule struct {
+	Manufacturer   string `yaml:"manufacturer"`
+	Model          string `yaml:"model"`
+	Virtualization string `yaml:"virtualization"`
+	Type           string `yaml:"type"`
+}
+
+type ItemAgeRule struct {
+	Key    string        `yaml:"key"`
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+type TagCondition struct {
+	Tag   string `yaml:"tag"`
+	Value string `yaml:"value"`
+	// equals, contains, exists or not_exists
+	Operator string `yaml:"operator"`
+}
+
 type SyncConfig struct {
-	UnidentifiableManufacturers []string `yaml:"unidentifiable_manufacturers"`
+	UnidentifiableManufacturers []string             `yaml:"unidentifiable_manufacturers"`
+	VirtualizationItem          string               `yaml:"virtualization_item"`
+	Classification              []ClassificationRule `yaml:"classification"`
+	Journal                     JournalConfig        `yaml:"journal"`
+	LastSeenField               string               `yaml:"last_seen_field"`
+	StaleAfter                  time.Duration        `yaml:"stale_after"`
+	ItemMaxAge                  []ItemAgeRule        `yaml:"item_max_age"`
+	StaleItems                  string               `yaml:"stale_items"`
+	HostState                   HostStateConfig      `yaml:"host_state"`
+	SNMP                        SNMPConfig           `yaml:"snmp"`
+	OobInterface                string               `yaml:"oob_interface"`
+	ReverseDNS                  ReverseDNSConfig     `yaml:"reverse_dns"`
+	Inventory                   InventoryConfig      `yaml:"inventory"`
+	Macros                      MacroConfig          `yaml:"macros"`
+	SiteFromMetadata            bool                 `yaml:"site_from_metadata"`
+}
+
+type CredentialsConfig struct {
+	NetBoxToken      string `yaml:"netbox_token"`
+	ZabbixUser       string `yaml:"zabbix_user"`
+	ZabbixPassphrase string `yaml:"zabbix_passphrase"`
+	ZabbixToken      string `yaml:"zabbix_token"`
+	WebhookToken     string `yaml:"webhook_token"`
+}
+
+type RetryConfig struct {
+	Attempts int           `yaml:"attempts"`
+	MinDelay time.Duration `yaml:"min_delay"`
+	MaxDelay time.Duration `yaml:"max_delay"`
+}
+
+type HTTPClientConfig struct {
+	CAFile             string            `yaml:"ca_file"`
+	CertFile           string            `yaml:"cert_file"`
+	KeyFile            string            `yaml:"key_file"`
+	InsecureSkipVerify bool              `yaml:"insecure_skip_verify"`
+	Proxy              string            `yaml:"proxy"`
+	Timeout            time.Duration     `yaml:"timeout"`
+	Headers            map[string]string `yaml:"headers"`
+	Retry              RetryConfig       `yaml:"retry"`
+}
+
+type ClientsConfig struct {
+	NetBox HTTPClientConfig `yaml:"netbox"`
+	Zabbix HTTPClientConfig `yaml:"zabbix"`
+}
+
+type DaemonConfig struct {
+	Interval time.Duration `yaml:"interval"`
+}
+
+type ServerConfig struct {
+	Listen string `yaml:"listen"`
+}
+
+type AuditConfig struct {
+	File string `yaml:"file"`
 }
 
 type Config struct {
-	NetBox     string     `yaml:"netbox"`
-	Zabbix     string     `yaml:"zabbix"`
-	HostGroups []string   `yaml:"hostgroups"`
-	Sync       SyncConfig `yaml:"sync"`
+	NetBox             string            `yaml:"netbox"`
+	Zabbix             string            `yaml:"zabbix"`
+	HostGroups         []string          `yaml:"hostgroups"`
+	ExcludeHostGroups  []string          `yaml:"exclude_hostgroups"`
+	HostGroupSubgroups bool              `yaml:"hostgroup_subgroups"`
+	Tags               []TagCondition    `yaml:"tags"`
+	ExcludeTags        []TagCondition    `yaml:"exclude_tags"`
+	ExcludeHosts       []string          `yaml:"exclude_hosts"`
+	Credentials        CredentialsConfig `yaml:"credentials"`
+	Clients            ClientsConfig     `yaml:"clients"`
+	Daemon             DaemonConfig      `yaml:"daemon"`
+	Server             ServerConfig      `yaml:"server"`
+	Safety             SafetyConfig      `yaml:"safety"`
+	Audit              AuditConfig       `yaml:"audit"`
+	Sync               SyncConfig        `yaml:"sync"`
+	// compiled "re:" patterns of the host groups, keyed by the pattern
+	hostGroupExpressions map[string]*regexp.Regexp
 }
 
 func readConfig(configPath string) (*Config, error) {
@@ -55,5 +148,178 @@ func readConfig(configPath string) (*Config, error) {
 		return nil, fmt.Errorf("Configuration key 'hostgroups' is required, set empty array to disable filtering.")
 	}
 
+	config.hostGroupExpressions, err = compileHostGroupExpressions(append(slices.Clone(config.HostGroups), config.ExcludeHostGroups...))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateSelectors(config.ExcludeHosts); err != nil {
+		return nil, err
+	}
+
+	for _, conditions := range [][]TagCondition{config.Tags, config.ExcludeTags} {
+		for i, condition := range conditions {
+			if condition.Operator == "" {
+				conditions[i].Operator = "equals"
+			}
+
+			if _, ok := tagOperators[conditions[i].Operator]; !ok || condition.Tag == "" {
+				return nil, fmt.Errorf("Invalid tag condition %+v, 'tag' is required and 'operator' needs to be 'equals', 'contains', 'exists' or 'not_exists'.", condition)
+			}
+		}
+	}
+
+	if config.Daemon.Interval == 0 {
+		config.Daemon.Interval = time.Hour
+	}
+
+	if config.Daemon.Interval < 0 {
+		return nil, fmt.Errorf("Configuration key 'daemon.interval' needs to be positive.")
+	}
+
+	for _, retry := range []*RetryConfig{&config.Clients.NetBox.Retry, &config.Clients.Zabbix.Retry} {
+		if retry.Attempts == 0 {
+			retry.Attempts = 5
+		}
+
+		if retry.MinDelay == 0 {
+			retry.MinDelay = time.Second
+		}
+
+		if retry.MaxDelay == 0 {
+			retry.MaxDelay = 30 * time.Second
+		}
+
+		if retry.Attempts < 1 || retry.MinDelay < 0 || retry.MaxDelay < retry.MinDelay {
+			return nil, fmt.Errorf("Invalid retry configuration %+v, 'attempts' needs to be positive and 'max_delay' needs to be larger than 'min_delay'.", *retry)
+		}
+	}
+
+	if config.Sync.StaleAfter < 0 {
+		return nil, fmt.Errorf("Configuration key 'sync.stale_after' needs to be positive.")
+	}
+
+	for i, rule := range config.Sync.ItemMaxAge {
+		if rule.Key == "" || rule.MaxAge <= 0 {
+			return nil, fmt.Errorf("Item age rule %d needs a 'key' and a positive 'max_age'.", i)
+		}
+	}
+
+	if config.Sync.StaleItems == "" {
+		config.Sync.StaleItems = "partial"
+	}
+
+	if !contains([]string{"partial", "skip"}, config.Sync.StaleItems) {
+		return nil, fmt.Errorf("Configuration key 'sync.stale_items' needs to be 'partial' or 'skip'.")
+	}
+
+	for state, policy := range map[string]*HostStatePolicy{"disabled": &config.Sync.HostState.Disabled, "maintenance": &config.Sync.HostState.Maintenance} {
+		if policy.Action == "" {
+			policy.Action = "sync"
+		}
+
+		if !contains([]string{"sync", "skip", "status", "inventory"}, policy.Action) {
+			return nil, fmt.Errorf("Configuration key 'sync.host_state.%s.action' needs to be 'sync', 'skip', 'status' or 'inventory'.", state)
+		}
+
+		if policy.Action != "status" {
+			continue
+		}
+
+		// the status needs to be valid for both devices and virtual machines
+		_, derr := netbox.NewDeviceStatusValueFromValue(policy.Status)
+		_, verr := netbox.NewInventoryItemStatusValueFromValue(policy.Status)
+		if derr != nil || verr != nil {
+			return nil, fmt.Errorf("Configuration key 'sync.host_state.%s.status' needs to be a NetBox device status such as 'offline' or 'planned'.", state)
+		}
+	}
+
+	if config.Sync.SNMP.Role == "" {
+		config.Sync.SNMP.Role = "Server"
+	}
+
+	if config.Sync.SNMP.Items.Manufacturer == "" {
+		config.Sync.SNMP.Items.Manufacturer = "system.hw.manufacturer"
+	}
+
+	if config.Sync.SNMP.Items.Model == "" {
+		config.Sync.SNMP.Items.Model = "system.hw.model"
+	}
+
+	if config.Sync.SNMP.Items.Serial == "" {
+		config.Sync.SNMP.Items.Serial = "system.hw.serialnumber"
+	}
+
+	if config.Sync.OobInterface == "" {
+		config.Sync.OobInterface = "bmc"
+	}
+
+	if config.Sync.ReverseDNS.Timeout == 0 {
+		config.Sync.ReverseDNS.Timeout = 5 * time.Second
+	}
+
+	if resolver := config.Sync.ReverseDNS.Resolver; resolver != "" {
+		if _, _, err := net.SplitHostPort(resolver); err != nil {
+			// a bare address uses the default DNS port
+			config.Sync.ReverseDNS.Resolver = net.JoinHostPort(resolver, "53")
+		}
+	}
+
+	for field, source := range config.Sync.Inventory.Precedence {
+		if _, ok := inventoryFields[field]; !ok {
+			return nil, fmt.Errorf("Invalid field '%s' in 'sync.inventory.precedence'.", field)
+		}
+
+		if !contains([]string{"items", "inventory"}, source) {
+			return nil, fmt.Errorf("Configuration key 'sync.inventory.precedence.%s' needs to be 'items' or 'inventory'.", field)
+		}
+	}
+
+	for key, name := range config.Sync.Macros.Keys {
+		config.Sync.Macros.Keys[key] = normalizeMacroName(name)
+	}
+
+	if config.Sync.Macros.Precedence == "" {
+		config.Sync.Macros.Precedence = "metadata"
+	}
+
+	if !contains([]string{"metadata", "macros"}, config.Sync.Macros.Precedence) {
+		return nil, fmt.Errorf("Configuration key 'sync.macros.precedence' needs to be 'metadata' or 'macros'.")
+	}
+
+	if config.Sync.Journal.Verbosity == "" {
+		config.Sync.Journal.Verbosity = "none"
+	}
+
+	if !contains([]string{"none", "summary", "detailed"}, config.Sync.Journal.Verbosity) {
+		return nil, fmt.Errorf("Configuration key 'sync.journal.verbosity' needs to be 'none', 'summary' or 'detailed'.")
+	}
+
+	if config.Sync.Journal.Kind == "" {
+		config.Sync.Journal.Kind = "info"
+	}
+
+	if _, err := netbox.NewJournalEntryKindValueFromValue(config.Sync.Journal.Kind); err != nil {
+		return nil, fmt.Errorf("Configuration key 'sync.journal.kind' needs to be 'info', 'success', 'warning' or 'danger'.")
+	}
+
+	if config.Sync.Classification == nil {
+		// cloned as the rules are modified below, which would otherwise affect later reloads
+		config.Sync.Classification = slices.Clone(defaultClassification)
+	}
+
+	for i, rule := range config.Sync.Classification {
+		if rule.Manufacturer == "" && rule.Model == "" && rule.Virtualization == "" {
+			return nil, fmt.Errorf("Classification rule %d has no 'manufacturer', 'model' or 'virtualization' to match.", i)
+		}
+
+		objtype := convertObjType(rule.Type)
+		if objtype == "" {
+			return nil, fmt.Errorf("Classification rule %d has invalid type '%s', use 'virtual' or 'physical'.", i, rule.Type)
+		}
+
+		config.Sync.Classification[i].Type = objtype
+	}
+
 	return config, nil
 }
@@ -0,0 +1,98 @@
+/*
+   Zabbix -> NetBox synchronization tool
+   Copyright (C) 2025  SUSE LLC <georg.pfuetzenreuter@suse.com>
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func readCredentialFile(name string, path string) (string, error) {
+	// os.Stat follows symlinks, which is what Kubernetes secret volumes consist of
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("Credential file %s for %s does not exist", path, name)
+	}
+	if err != nil {
+		return "", fmt.Errorf("Could not access credential file %s for %s: %s", path, name, err)
+	}
+
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("Credential file %s for %s is not a regular file", path, name)
+	}
+
+	mode := info.Mode().Perm()
+	if mode&0007 != 0 {
+		return "", fmt.Errorf("Credential file %s for %s is accessible by other users (mode %04o)", path, name, mode)
+	}
+	if mode&0070 != 0 {
+		Warn("Credential file %s for %s is accessible by the owning group (mode %04o)", path, name, mode)
+	}
+
+	buffer, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("Could not read credential file %s for %s: %s", path, name, err)
+	}
+
+	Debug("Read %s from %s", name, path)
+
+	return strings.TrimSpace(string(buffer)), nil
+}
+
+// looks up a credential in the following order:
+// - environment variable
+// - file referenced by the environment variable suffixed with _FILE
+// - file referenced in the configuration, relative paths are resolved against $CREDENTIALS_DIRECTORY
+// - file named after the configuration key in $CREDENTIALS_DIRECTORY
+// an empty string is returned if none of them is set
+func readCredential(name string, env string, configured string) (string, error) {
+	if value := os.Getenv(env); value != "" {
+		Debug("Read %s from environment variable %s", name, env)
+
+		return value, nil
+	}
+
+	if path := os.Getenv(env + "_FILE"); path != "" {
+		return readCredentialFile(name, path)
+	}
+
+	directory := os.Getenv("CREDENTIALS_DIRECTORY")
+
+	if configured != "" {
+		path := configured
+		if !filepath.IsAbs(path) && directory != "" {
+			path = filepath.Join(directory, path)
+		}
+
+		return readCredentialFile(name, path)
+	}
+
+	if directory != "" {
+		path := filepath.Join(directory, name)
+		if _, err := os.Stat(path); err == nil {
+			return readCredentialFile(name, path)
+		}
+	}
+
+	return "", nil
+}
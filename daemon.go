@@ -0,0 +1,252 @@
+/*
+   Zabbix -> NetBox synchronization tool
+   Copyright (C) 2025  SUSE LLC <georg.pfuetzenreuter@suse.com>
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var (
+	// set once a termination signal was received, the sync stops before processing the next host
+	shutdownRequested atomic.Bool
+
+	// in daemon mode, Fatal() panics instead of exiting so the error only aborts the current run
+	// read by the webhook handlers, hence atomic
+	fatalPanics atomic.Bool
+)
+
+type fatalError struct {
+	message string
+	// error of the failed request, or errNoHosts, if any
+	cause error
+}
+
+func (f fatalError) Error() string {
+	return f.message
+}
+
+func (f fatalError) Unwrap() error {
+	return f.cause
+}
+
+// runs fn and returns the message passed to Fatal() as an error instead of terminating the program
+func catchFatal(fn func()) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			fatal, ok := recovered.(fatalError)
+			if !ok {
+				panic(recovered)
+			}
+
+			err = fatal
+		}
+	}()
+
+	fn()
+
+	return nil
+}
+
+// returns the error passed to Fatal() during the run like any other error
+func runCatchingFatal(r *runner, options runOptions) (zabbixHosts, error) {
+	var zh zabbixHosts
+	var err error
+
+	if fatal := catchFatal(func() {
+		zh, err = r.run(options.dryRun, options.force, options.selection)
+	}); fatal != nil {
+		err = fatal
+	}
+
+	return zh, err
+}
+
+// only failed requests before any change was applied are retried, as repeating a partially applied wet run or a failed
+// check would not help
+func isRetryable(r *runner, err error) bool {
+	var fatal fatalError
+
+	return errors.As(err, &fatal) && fatal.cause != nil && !errors.Is(fatal.cause, errNoHosts) && !r.applying
+}
+
+// creates a runner with fresh connections, keeps the existing one if this fails
+func reconnect(r *runner, config *Config) *runner {
+	var replacement *runner
+
+	err := catchFatal(func() {
+		replacement = newRunner(config)
+	})
+	if err != nil {
+		Error("Reconnecting failed, keeping previous connections: %s", err)
+
+		return r
+	}
+
+	r.close()
+
+	return replacement
+}
+
+func reloadConfig(r *runner, configPath string) *runner {
+	config, err := readConfig(configPath)
+	if err != nil {
+		Error("Reloading configuration failed, keeping previous configuration: %s", err)
+
+		return r
+	}
+
+	Info("Reloaded configuration from %s", configPath)
+
+	return reconnect(r, config)
+}
+
+// serializes sync runs between the schedule and the webhook server and guards replacing the runner
+type daemonState struct {
+	lock   chan struct{}
+	runner *runner
+	// readable without holding the lock, to reject unauthenticated requests without waiting for a running sync
+	webhookToken atomic.Pointer[string]
+}
+
+func (d *daemonState) acquire() *runner {
+	d.lock <- struct{}{}
+
+	return d.runner
+}
+
+func (d *daemonState) release() {
+	<-d.lock
+}
+
+// the lock needs to be held by the caller
+func (d *daemonState) setRunner(r *runner) {
+	d.runner = r
+	d.webhookToken.Store(&r.webhookToken)
+}
+
+func daemon(configPath string, config *Config, options runOptions) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
+
+	reload := make(chan struct{}, 1)
+	stop := make(chan struct{})
+
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGHUP {
+				Info("Received %s, reloading configuration.", sig)
+				select {
+				case reload <- struct{}{}:
+				default:
+				}
+
+				continue
+			}
+
+			if shutdownRequested.Swap(true) {
+				Warn("Received %s again, exiting immediately.", sig)
+				os.Exit(1)
+			}
+
+			Info("Received %s, shutting down after the current host.", sig)
+			close(stop)
+		}
+	}()
+
+	state := &daemonState{
+		lock: make(chan struct{}, 1),
+	}
+
+	// a failure to connect on startup is fatal, later failures only affect the current run
+	state.setRunner(newRunner(config))
+
+	var server *http.Server
+	if config.Server.Listen != "" {
+		server = startServer(config.Server, state, options.dryRun)
+	}
+
+	fatalPanics.Store(true)
+
+	for !shutdownRequested.Load() {
+		r := state.acquire()
+
+		start := time.Now()
+		Info("Starting sync run")
+
+		zh, err := runCatchingFatal(r, options)
+
+		if isRetryable(r, err) && !shutdownRequested.Load() {
+			Warn("Sync run failed, reconnecting: %s", err)
+
+			// the session might have expired, in which case the run succeeds with fresh connections
+			if replacement := reconnect(r, r.config); replacement != r {
+				finishRun(&zh, start, err, options)
+
+				state.setRunner(replacement)
+				r = replacement
+
+				start = time.Now()
+				Info("Retrying sync run")
+				zh, err = runCatchingFatal(r, options)
+			}
+		}
+
+		finishRun(&zh, start, err, options)
+
+		if err == nil {
+			Info("Finished sync run after %s", time.Since(start).Round(time.Millisecond))
+		} else {
+			Error("Sync run failed after %s: %s", time.Since(start).Round(time.Millisecond), err)
+		}
+
+		interval := state.runner.config.Daemon.Interval
+		state.release()
+
+		if shutdownRequested.Load() {
+			break
+		}
+
+		Debug("Next sync run in %s", interval)
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-timer.C:
+		case <-reload:
+			timer.Stop()
+			r := state.acquire()
+			state.setRunner(reloadConfig(r, configPath))
+			state.release()
+		case <-stop:
+			timer.Stop()
+		}
+	}
+
+	if server != nil {
+		stopServer(server)
+	}
+
+	state.acquire().close()
+	Info("Shut down.")
+}
@@ -0,0 +1,109 @@
+/*
+   Zabbix -> NetBox synchronization tool
+   Copyright (C) 2025  SUSE LLC <georg.pfuetzenreuter@suse.com>
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+type ReverseDNSConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// host and port of the DNS server, the system resolver is used if empty
+	Resolver string        `yaml:"resolver"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+func newResolver(config ReverseDNSConfig) *net.Resolver {
+	if config.Resolver == "" {
+		return net.DefaultResolver
+	}
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, config.Resolver)
+		},
+	}
+}
+
+// returns the first name of the address which resolves back to it
+func reverseLookup(resolver *net.Resolver, address string, timeout time.Duration) (string, error) {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return "", fmt.Errorf("Invalid IP address '%s'", address)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	names, err := resolver.LookupAddr(ctx, address)
+	if err != nil {
+		return "", err
+	}
+
+	for _, name := range names {
+		name = strings.TrimSuffix(name, ".")
+
+		addresses, err := resolver.LookupHost(ctx, name)
+		if err != nil {
+			logger.Debug("Forward lookup failed", "name", name, "error", err)
+			continue
+		}
+
+		for _, forward := range addresses {
+			if ip.Equal(net.ParseIP(forward)) {
+				return name, nil
+			}
+		}
+
+		logger.Debug("Forward lookup does not match", "name", name, "address", address, "addresses", addresses)
+	}
+
+	return "", fmt.Errorf("None of the names %v resolve to %s", names, address)
+}
+
+// names the selected hosts with an empty DNS field after their address, lookups can be slow hence other hosts are left out
+func resolveHostNames(zh *zabbixHosts, config ReverseDNSConfig) {
+	resolver := newResolver(config)
+
+	for _, host := range *zh {
+		if !host.Scanned || !host.ResolveName {
+			continue
+		}
+
+		name, err := reverseLookup(resolver, host.PrimaryAddress, config.Timeout)
+		if err != nil {
+			host.log.Warn("Reverse lookup failed", "ip", host.PrimaryAddress, "error", err)
+			host.log.Error("Empty DNS field in host interface", "ip", host.PrimaryAddress)
+			host.Error = true
+			host.Reasons = append(host.Reasons, "empty_dns")
+
+			continue
+		}
+
+		host.log.Info("Resolved empty DNS field in host interface", "ip", host.PrimaryAddress, "name", name)
+		host.HostName = name
+		host.setLogger()
+	}
+}
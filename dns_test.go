@@ -0,0 +1,180 @@
+/*
+   Zabbix -> NetBox synchronization tool
+   Copyright (C) 2025  SUSE LLC <georg.pfuetzenreuter@suse.com>
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	dnsTypeA   = 1
+	dnsTypePTR = 12
+)
+
+// answers of the stub DNS server, keyed by the question name without the trailing dot and the question type
+type dnsRecords map[string]map[uint16][]byte
+
+func encodeDNSName(name string) []byte {
+	var encoded []byte
+
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		encoded = append(encoded, byte(len(label)))
+		encoded = append(encoded, label...)
+	}
+
+	return append(encoded, 0)
+}
+
+// returns the question name and the offset following it
+func decodeDNSName(message []byte, offset int) (string, int) {
+	var labels []string
+
+	for offset < len(message) && message[offset] != 0 {
+		length := int(message[offset])
+		labels = append(labels, string(message[offset+1:offset+1+length]))
+		offset += 1 + length
+	}
+
+	return strings.Join(labels, "."), offset + 1
+}
+
+// answers a single question with the matching record, or with an empty answer
+func buildDNSResponse(query []byte, records dnsRecords) []byte {
+	name, offset := decodeDNSName(query, 12)
+	qtype := binary.BigEndian.Uint16(query[offset:])
+	question := query[12 : offset+4]
+
+	data, found := records[strings.ToLower(name)][qtype]
+
+	response := make([]byte, 12, 512)
+	copy(response, query[:2])
+	// response, recursion desired and available
+	binary.BigEndian.PutUint16(response[2:], 0x8180)
+	binary.BigEndian.PutUint16(response[4:], 1)
+	if found {
+		binary.BigEndian.PutUint16(response[6:], 1)
+	}
+
+	response = append(response, question...)
+
+	if found {
+		// the answer refers to the question name at offset 12
+		response = append(response, 0xc0, 0x0c)
+		response = binary.BigEndian.AppendUint16(response, qtype)
+		response = binary.BigEndian.AppendUint16(response, 1)
+		response = binary.BigEndian.AppendUint32(response, 60)
+		response = binary.BigEndian.AppendUint16(response, uint16(len(data)))
+		response = append(response, data...)
+	}
+
+	return response
+}
+
+// starts a DNS server on a random UDP port and returns its address, without records it never responds
+func startDNSServer(t *testing.T, records dnsRecords) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listening failed: %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buffer := make([]byte, 512)
+
+		for {
+			n, address, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+
+			if records == nil {
+				continue
+			}
+
+			conn.WriteTo(buildDNSResponse(buffer[:n], records), address)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func setupTestLogger() {
+	logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func TestReverseLookupConfirmed(t *testing.T) {
+	setupTestLogger()
+
+	resolver := newResolver(ReverseDNSConfig{Resolver: startDNSServer(t, dnsRecords{
+		"10.2.0.192.in-addr.arpa": {dnsTypePTR: encodeDNSName("host.example.test.")},
+		"host.example.test":       {dnsTypeA: net.ParseIP("192.0.2.10").To4()},
+	})})
+
+	name, err := reverseLookup(resolver, "192.0.2.10", 5*time.Second)
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+
+	if name != "host.example.test" {
+		t.Errorf("Expected name 'host.example.test', got '%s'", name)
+	}
+}
+
+func TestReverseLookupNotConfirmed(t *testing.T) {
+	setupTestLogger()
+
+	resolver := newResolver(ReverseDNSConfig{Resolver: startDNSServer(t, dnsRecords{
+		"10.2.0.192.in-addr.arpa": {dnsTypePTR: encodeDNSName("other.example.test.")},
+		"other.example.test":      {dnsTypeA: net.ParseIP("192.0.2.99").To4()},
+	})})
+
+	name, err := reverseLookup(resolver, "192.0.2.10", 5*time.Second)
+	if err == nil {
+		t.Fatalf("Expected an error as the name resolves to a different address, got name '%s'", name)
+	}
+
+	if !strings.Contains(err.Error(), "other.example.test") {
+		t.Errorf("Expected the error to name the unconfirmed name, got '%s'", err)
+	}
+}
+
+func TestReverseLookupTimeout(t *testing.T) {
+	setupTestLogger()
+
+	resolver := newResolver(ReverseDNSConfig{Resolver: startDNSServer(t, nil)})
+
+	start := time.Now()
+
+	name, err := reverseLookup(resolver, "192.0.2.10", 200*time.Millisecond)
+	if err == nil {
+		t.Fatalf("Expected an error as the server does not respond, got name '%s'", name)
+	}
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Lookup took %s despite the timeout", elapsed)
+	}
+}
@@ -0,0 +1,95 @@
+/*
+   Zabbix -> NetBox synchronization tool
+   Copyright (C) 2025  SUSE LLC <georg.pfuetzenreuter@suse.com>
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"github.com/fabiang/go-zabbix"
+)
+
+type HostStatePolicy struct {
+	// sync, skip, status or inventory
+	Action string `yaml:"action"`
+	// NetBox status of hosts in the state, used with the status action
+	Status string `yaml:"status"`
+}
+
+type HostStateConfig struct {
+	Disabled    HostStatePolicy `yaml:"disabled"`
+	Maintenance HostStatePolicy `yaml:"maintenance"`
+}
+
+// returns the policy for the Zabbix state of the host, the disabled state takes precedence over maintenance
+func hostStatePolicy(host *zabbixHostData, config SyncConfig) (string, HostStatePolicy) {
+	if host.Disabled && config.HostState.Disabled.Action != "sync" {
+		return "disabled", config.HostState.Disabled
+	}
+
+	if host.Maintenance && config.HostState.Maintenance.Action != "sync" {
+		return "maintenance", config.HostState.Maintenance
+	}
+
+	return "", HostStatePolicy{Action: "sync"}
+}
+
+// whether a status policy sets the status, such statuses carrying the status tag are reset once the host leaves the state
+func isHostStateStatus(status string, config SyncConfig) bool {
+	for _, policy := range []HostStatePolicy{config.HostState.Disabled, config.HostState.Maintenance} {
+		if policy.Action == "status" && policy.Status == status {
+			return true
+		}
+	}
+
+	return false
+}
+
+// stores the Zabbix state of the selected hosts, skips hosts as configured and returns the IDs of the remaining ones
+func applyHostStates(zh *zabbixHosts, workHosts []zabbix.Host, config SyncConfig) []string {
+	hostIds := []string{}
+
+	for _, h := range workHosts {
+		host, ok := (*zh)[h.HostID]
+		if !ok || !host.Scanned {
+			continue
+		}
+
+		host.Disabled = h.Status == 1
+		host.Maintenance = h.MaintenanceStatus == "1"
+
+		if host.Skipped {
+			continue
+		}
+
+		state, policy := hostStatePolicy(host, config)
+		if policy.Action == "skip" {
+			host.log.Info("Skipping host due to its Zabbix state", "state", state)
+			host.Skipped = true
+			host.Reasons = append(host.Reasons, state)
+
+			continue
+		}
+
+		if state != "" {
+			host.log.Debug("Applying Zabbix state policy", "state", state, "action", policy.Action)
+		}
+
+		hostIds = append(hostIds, h.HostID)
+	}
+
+	return hostIds
+}
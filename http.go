@@ -0,0 +1,139 @@
+/*
+   Zabbix -> NetBox synchronization tool
+   Copyright (C) 2025  SUSE LLC <georg.pfuetzenreuter@suse.com>
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// adds static headers to every request
+type headerTransport struct {
+	base    http.RoundTripper
+	headers map[string]string
+}
+
+func (t *headerTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	// RoundTrippers must not modify the original request
+	request = request.Clone(request.Context())
+	for key, value := range t.headers {
+		request.Header.Set(key, value)
+	}
+
+	return t.base.RoundTrip(request)
+}
+
+func newTLSConfig(name string, config HTTPClientConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if config.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			Warn("Could not load system certificate pool, using only %s for %s: %s", config.CAFile, name, err)
+			pool = x509.NewCertPool()
+		}
+
+		buffer, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read CA file for %s: %s", name, err)
+		}
+
+		if !pool.AppendCertsFromPEM(buffer) {
+			return nil, fmt.Errorf("CA file %s for %s does not contain any PEM certificates", config.CAFile, name)
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.CertFile != "" || config.KeyFile != "" {
+		if config.CertFile == "" || config.KeyFile == "" {
+			return nil, fmt.Errorf("Client certificate for %s requires both 'cert_file' and 'key_file'", name)
+		}
+
+		certificate, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Could not load client certificate for %s: %s", name, err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	if config.InsecureSkipVerify {
+		Warn("TLS certificate verification for %s is disabled.", name)
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	return tlsConfig, nil
+}
+
+func newHTTPClient(name string, config HTTPClientConfig, idempotent idempotencyFunc, endpoint func(*http.Request) string) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	tlsConfig, err := newTLSConfig(name, config)
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	// without an explicit proxy, the default transport honours the HTTP(S)_PROXY and NO_PROXY environment variables
+	if config.Proxy != "" {
+		proxy, err := url.Parse(config.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid proxy URL for %s: %s", name, err)
+		}
+
+		Debug("Using proxy %s for %s", proxy.Redacted(), name)
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	var roundTripper http.RoundTripper = transport
+
+	if len(config.Headers) > 0 {
+		roundTripper = &headerTransport{
+			base:    roundTripper,
+			headers: config.Headers,
+		}
+	}
+
+	roundTripper = &metricsTransport{
+		base:     roundTripper,
+		api:      strings.ToLower(name),
+		endpoint: endpoint,
+	}
+
+	// the timeout is applied to each attempt by the retry transport instead of using http.Client.Timeout, which would span all attempts
+	roundTripper = &retryTransport{
+		base:       roundTripper,
+		name:       name,
+		config:     config.Retry,
+		timeout:    config.Timeout,
+		idempotent: idempotent,
+	}
+
+	return &http.Client{
+		Transport: roundTripper,
+	}, nil
+}
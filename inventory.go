@@ -0,0 +1,176 @@
+/*
+   Zabbix -> NetBox synchronization tool
+   Copyright (C) 2025  SUSE LLC <georg.pfuetzenreuter@suse.com>
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"github.com/fabiang/go-zabbix"
+	"github.com/netbox-community/go-netbox/v4"
+)
+
+// host inventory fields providing the value of each synced field, the first non-empty one is used
+var inventoryFields = map[string][]string{
+	"serial":       {"serialno_a", "serialno_b"},
+	"manufacturer": {"vendor"},
+	"model":        {"model"},
+	"os":           {"os_short", "os"},
+	"location":     {"location"},
+	"asset_tag":    {"asset_tag"},
+}
+
+type InventoryConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// per field, "items" or "inventory", the other source is used if the preferred one has no value
+	Precedence map[string]string `yaml:"precedence"`
+}
+
+func inventorySelect() []string {
+	var fields []string
+	for _, names := range inventoryFields {
+		fields = append(fields, names...)
+	}
+
+	return fields
+}
+
+func inventoryValue(inventory zabbix.HostInventory, field string) string {
+	for _, name := range inventoryFields[field] {
+		if value := inventory[name]; value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// log attributes naming the sources of a missing value, the items and, if enabled, the host inventory fields
+func missingValueSources(config InventoryConfig, field string, items ...string) []any {
+	sources := []any{"items", items}
+	if config.Enabled {
+		sources = append(sources, "inventory", inventoryFields[field])
+	}
+
+	return sources
+}
+
+func setHostInventories(zh *zabbixHosts, workHosts []zabbix.Host) {
+	for _, h := range workHosts {
+		if host, ok := (*zh)[h.HostID]; ok {
+			host.Inventory = h.Inventory
+		}
+	}
+}
+
+// combines the values read from the items with the ones from the host inventory
+func applyInventory(host *zabbixHostData, config InventoryConfig) {
+	if !config.Enabled {
+		return
+	}
+
+	for field, target := range map[string]*string{
+		"serial":       &host.Serial,
+		"manufacturer": &host.Manufacturer,
+		"model":        &host.Model,
+		"os":           &host.OS,
+		"location":     &host.Location,
+		"asset_tag":    &host.AssetTag,
+	} {
+		value := inventoryValue(host.Inventory, field)
+		if value == "" || value == *target {
+			continue
+		}
+
+		if *target == "" || config.Precedence[field] == "inventory" {
+			host.log.Debug("Using host inventory value", "field", field, "item_value", *target, "inventory_value", value)
+			*target = value
+		}
+	}
+}
+
+// platforms and locations, queried once per sync to look up the inventory values of the hosts
+type inventoryObjects struct {
+	platforms []netbox.Platform
+	locations []netbox.Location
+}
+
+func getInventoryObjects(nb *netbox.APIClient, ctx context.Context, config InventoryConfig) inventoryObjects {
+	var objects inventoryObjects
+
+	if !config.Enabled {
+		return objects
+	}
+
+	for {
+		result, _, err := nb.DcimAPI.DcimPlatformsList(ctx).Offset(int32(len(objects.platforms))).Execute()
+		handleError("Querying platforms", err)
+		objects.platforms = append(objects.platforms, result.Results...)
+
+		if result.GetNext() == "" || len(result.Results) == 0 {
+			break
+		}
+	}
+
+	for {
+		result, _, err := nb.DcimAPI.DcimLocationsList(ctx).Offset(int32(len(objects.locations))).Execute()
+		handleError("Querying locations", err)
+		objects.locations = append(objects.locations, result.Results...)
+
+		if result.GetNext() == "" || len(result.Results) == 0 {
+			break
+		}
+	}
+
+	logger.Debug("Queried inventory objects", "platforms", len(objects.platforms), "locations", len(objects.locations))
+
+	return objects
+}
+
+// returns the platform matching the operating system of the host, or nil if there is none
+func inventoryPlatform(host *zabbixHostData, objects inventoryObjects, config SyncConfig) *netbox.Platform {
+	if !config.Inventory.Enabled || host.OS == "" {
+		return nil
+	}
+
+	for i, platform := range objects.platforms {
+		if platform.Name == host.OS {
+			return &objects.platforms[i]
+		}
+	}
+
+	host.log.Warn("Platform does not exist in NetBox", "object", "dcim.platform", "platform", host.OS)
+
+	return nil
+}
+
+// returns the location matching the inventory location of the host in the site, or nil if there is none
+func inventoryLocation(host *zabbixHostData, objects inventoryObjects, config SyncConfig, siteSlug string) *netbox.Location {
+	if !config.Inventory.Enabled || host.Location == "" {
+		return nil
+	}
+
+	for i, location := range objects.locations {
+		if location.Name == host.Location && location.Site.Slug == siteSlug {
+			return &objects.locations[i]
+		}
+	}
+
+	host.log.Warn("Location does not exist in NetBox", "object", "dcim.location", "location", host.Location, "site", siteSlug)
+
+	return nil
+}
@@ -77,6 +77,20 @@ func (infs ipRoute2Interfaces) String() string {
 	return strings.Join(out[:], "")
 }
 
+func (inf *ipRoute2Interface) hasAddress(address string) bool {
+	if address == "" {
+		return false
+	}
+
+	for _, addr := range inf.AddrInfo {
+		if addr.Local == address {
+			return true
+		}
+	}
+
+	return false
+}
+
 func parseIpRoute2AddressData(raw string) *ipRoute2Interface {
 	if raw == "" {
 		return nil
@@ -0,0 +1,100 @@
+/*
+   Zabbix -> NetBox synchronization tool
+   Copyright (C) 2025  SUSE LLC <georg.pfuetzenreuter@suse.com>
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/netbox-community/go-netbox/v4"
+	"slices"
+	"strings"
+)
+
+// changes to other fields are frequent and not worth a journal entry
+var journalFields = []string{"site", "device_type", "serial", "status"}
+
+type JournalConfig struct {
+	// none, summary or detailed
+	Verbosity string `yaml:"verbosity"`
+	// info, success, warning or danger
+	Kind string `yaml:"kind"`
+}
+
+func formatJournalEntry(host *zabbixHostData, changes []change, run string, verbosity string) string {
+	var comments strings.Builder
+
+	fmt.Fprintf(&comments, "Synced from Zabbix host `%s` (ID %s) by zabbix-netbox-sync run `%s`.\n\n", host.HostName, host.HostID, run)
+
+	for _, c := range changes {
+		if c.Action == "create" {
+			fmt.Fprintf(&comments, "- created\n")
+		} else {
+			fmt.Fprintf(&comments, "- %s: `%v` → `%v`\n", c.Field, c.Old, c.New)
+		}
+	}
+
+	if verbosity == "detailed" && len(host.Metrics) > 0 {
+		metrics := slices.Clone(host.Metrics)
+		slices.SortFunc(metrics, func(a, b zabbixMetric) int {
+			return strings.Compare(a.Key, b.Key)
+		})
+
+		comments.WriteString("\nZabbix items:\n\n")
+
+		for _, metric := range metrics {
+			fmt.Fprintf(&comments, "- `%s`: `%s`\n", metric.Key, strings.ReplaceAll(metric.Value, "`", "'"))
+		}
+	}
+
+	return comments.String()
+}
+
+// adds a journal entry to a created device or virtual machine, or one with changes to significant fields
+func addJournalEntry(host *zabbixHostData, nb *netbox.APIClient, ctx context.Context, objtype string, objid int32, config JournalConfig) {
+	if config.Verbosity == "none" {
+		return
+	}
+
+	var changes []change
+	for _, c := range host.Changes {
+		if c.Object == objtype && (c.Action == "create" || contains(journalFields, c.Field)) {
+			changes = append(changes, c)
+		}
+	}
+
+	if len(changes) == 0 {
+		return
+	}
+
+	log := host.log.With("object", "extras.journalentry", "assigned_object_type", objtype, "assigned_object_id", objid)
+
+	run, _ := ctx.Value(auditRunKey{}).(string)
+
+	request := *netbox.NewWritableJournalEntryRequest(objtype, int64(objid), formatJournalEntry(host, changes, run, config.Verbosity))
+
+	kind, err := netbox.NewJournalEntryKindValueFromValue(config.Kind)
+	handleError("Validation of journal entry kind", err)
+	request.SetKind(*kind)
+
+	log.Info("Creating journal entry")
+	log.Debug("Payload", "payload", request)
+
+	created, response, rerr := nb.ExtrasAPI.ExtrasJournalEntriesCreate(ctx).WritableJournalEntryRequest(request).Execute()
+	handleResponse(log, created, response, rerr)
+}
@@ -0,0 +1,122 @@
+/*
+   Zabbix -> NetBox synchronization tool
+   Copyright (C) 2025  SUSE LLC <georg.pfuetzenreuter@suse.com>
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"github.com/fabiang/go-zabbix"
+	"strings"
+)
+
+type MacroConfig struct {
+	// metadata keys mapped to the names of the Zabbix user macros providing their values
+	Keys map[string]string `yaml:"keys"`
+	// metadata or macros, decides which source wins if both provide a key
+	Precedence string `yaml:"precedence"`
+}
+
+type zabbixMacro struct {
+	Macro string `json:"macro"`
+	Value string `json:"value"`
+	// 0 is a text macro, secret and vault macros do not provide their value
+	Type int `json:"type,string"`
+}
+
+type zabbixMacroHost struct {
+	HostID          string        `json:"hostid"`
+	Macros          []zabbixMacro `json:"macros"`
+	InheritedMacros []zabbixMacro `json:"inheritedMacros"`
+}
+
+type zabbixMacroGetParams struct {
+	zabbix.HostGetParams
+	SelectInheritedMacros zabbix.SelectQuery `json:"selectInheritedMacros,omitempty"`
+}
+
+// accepts both "{$NAME}" and "NAME"
+func normalizeMacroName(name string) string {
+	if strings.HasPrefix(name, "{$") {
+		return name
+	}
+
+	return "{$" + name + "}"
+}
+
+func getHostMacros(z *zabbix.Session, hostIds []string) []zabbixMacroHost {
+	hosts := make([]zabbixMacroHost, 0)
+
+	err := z.Get("host.get", zabbixMacroGetParams{
+		HostGetParams: zabbix.HostGetParams{
+			GetParameters: zabbix.GetParameters{
+				OutputFields: []string{"hostid"},
+			},
+			HostIDs:      hostIds,
+			SelectMacros: zabbix.SelectExtendedOutput,
+		},
+		SelectInheritedMacros: zabbix.SelectExtendedOutput,
+	}, &hosts)
+	handleError("Querying host macros", err)
+
+	logger.Debug("Queried host macros", "hosts", hosts)
+
+	return hosts
+}
+
+// stores the values of the macros of each host, macros defined on the host override the ones inherited from templates
+func setHostMacros(zh *zabbixHosts, hosts []zabbixMacroHost) {
+	for _, h := range hosts {
+		host, ok := (*zh)[h.HostID]
+		if !ok {
+			continue
+		}
+
+		host.Macros = make(map[string]string)
+
+		for _, macros := range [][]zabbixMacro{h.InheritedMacros, h.Macros} {
+			for _, macro := range macros {
+				if macro.Type == 0 {
+					host.Macros[macro.Macro] = macro.Value
+				}
+			}
+		}
+	}
+}
+
+// merges the configured macros into the metadata of the host
+func mergeMacros(host *zabbixHostData, config MacroConfig) {
+	for key, name := range config.Keys {
+		value, ok := host.Macros[name]
+		if !ok || value == "" {
+			continue
+		}
+
+		if old, exists := host.Meta[key]; exists && old != value {
+			if config.Precedence != "macros" {
+				continue
+			}
+
+			host.log.Debug("Overriding metadata with macro", "key", key, "macro", name, "old", old, "new", value)
+		}
+
+		if host.Meta == nil {
+			host.Meta = make(zabbixHostMetaData)
+		}
+
+		host.Meta[key] = value
+	}
+}
@@ -19,60 +19,235 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"github.com/fabiang/go-zabbix"
+	"github.com/netbox-community/go-netbox/v4"
 	"log/slog"
 	"os"
+	"slices"
+	"time"
 )
 
 var (
 	logger *slog.Logger
 )
 
+// command line options applying to every run
+type runOptions struct {
+	dryRun       bool
+	selection    hostSelection
+	metricsFile  string
+	reportFormat string
+	reportFile   string
+	diffFile     string
+	force        bool
+}
+
 func main() {
 	var configPath string
 	var logLevelStr string
-	var limit string
-	var runDry bool
+	var logFormat string
 	var runWet bool
+	var runDaemon bool
+	var options runOptions
 
 	flag.StringVar(&configPath, "config", "./config.yaml", "Path to configuration file")
 	flag.StringVar(&logLevelStr, "loglevel", "info", "Logging level")
-	flag.StringVar(&limit, "limit", "", "Host to limit the sync to")
-	flag.BoolVar(&runDry, "dry", false, "Run without performing any changes")
+	flag.StringVar(&logFormat, "logformat", "json", "Logging format: json or text")
+	flag.Var((*listFlag)(&options.selection.limit), "limit", "Hosts to limit the sync to, comma separated or repeated: host name patterns, re:<expression>, id:<host ID> or group:<host group>")
+	flag.Var((*listFlag)(&options.selection.exclude), "exclude", "Hosts to exclude from the sync, same format as -limit")
+	flag.BoolVar(&options.dryRun, "dry", false, "Run without performing any changes")
 	flag.BoolVar(&runWet, "wet", false, "Run and perform changes")
+	flag.BoolVar(&runDaemon, "daemon", false, "Keep running and sync in the configured interval")
+	flag.StringVar(&options.metricsFile, "metrics-textfile", "", "Path to write Prometheus metrics to after each run")
+	flag.StringVar(&options.reportFormat, "report", "table", "Format of the report printed after each run: table, json or none")
+	flag.StringVar(&options.reportFile, "report-file", "", "Path to write the report to instead of standard output")
+	flag.StringVar(&options.diffFile, "diff", "", "Path to write the changes to as JSON Lines, '-' for standard output")
+	flag.BoolVar(&options.force, "force", false, "Apply changes even if safety thresholds are exceeded")
 	flag.Parse()
 
-	logger = slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: convertLogLevel(logLevelStr)}))
+	var validLogFormat bool
+	logger, validLogFormat = newLogger(logFormat, convertLogLevel(logLevelStr))
+	if !validLogFormat {
+		Fatal("Invalid log format '%s', use 'json' or 'text'.", logFormat)
+	}
 
 	config, err := readConfig(configPath)
 	if err != nil {
 		Fatal("%s", err)
 	}
 
-	if runDry && runWet {
+	if options.dryRun && runWet {
 		Fatal("Specify -dry OR -wet, not both.")
 	}
 
-	if !runDry && !runWet {
+	if !options.dryRun && !runWet {
 		Fatal("Specify -dry OR -wet.")
 	}
 
-	var netboxToken string
-	var zabbixUser string
-	var zabbixPassphrase string
+	if err := validateSelectors(append(slices.Clone(options.selection.limit), options.selection.exclude...)); err != nil {
+		Fatal("%s", err)
+	}
+
+	if !contains([]string{"table", "json", "none"}, options.reportFormat) {
+		Fatal("Invalid report format '%s', use 'table', 'json' or 'none'.", options.reportFormat)
+	}
+
+	if runDaemon {
+		daemon(configPath, config, options)
+
+		return
+	}
+
+	// failures are returned instead of exiting right away, so the metrics file still records the failed run
+	fatalPanics.Store(true)
+
+	start := time.Now()
+
+	var zh zabbixHosts
+	var r *runner
+	err = catchFatal(func() {
+		r = newRunner(config)
+	})
+	if err == nil {
+		zh, err = runCatchingFatal(r, options)
+		r.close()
+	}
 
-	netboxToken = os.Getenv("NETBOX_TOKEN")
-	zabbixUser = os.Getenv("ZABBIX_USER")
-	zabbixPassphrase = os.Getenv("ZABBIX_PASSPHRASE")
+	finishRun(&zh, start, err, options)
+
+	if err != nil {
+		Error("Sync run failed after %s: %s", time.Since(start).Round(time.Millisecond), err)
+		os.Exit(1)
+	}
+}
+
+// outputs the results of a scheduled or one-shot run
+func finishRun(zh *zabbixHosts, start time.Time, err error, options runOptions) {
+	observeRun(zh, start, err)
+
+	if options.metricsFile != "" {
+		writeMetricsFile(options.metricsFile)
+	}
+
+	// the hosts hold the changes which were refused, hence they are reported as a dry run
+	aborted := errors.Is(err, errSafetyThresholds)
+	if aborted {
+		options.dryRun = true
+	}
+
+	if err == nil || aborted {
+		writeReport(zh, options)
+
+		if options.diffFile != "" {
+			writeDiff(zh, options.diffFile)
+		}
+	}
+}
+
+// holds the API connections, which are kept across sync runs in daemon mode
+type runner struct {
+	config      *Config
+	z           *zabbix.Session
+	zabbixLogin bool
+	nb          *netbox.APIClient
+	nbctx       context.Context
+	// only used if the webhook server is enabled
+	webhookToken string
+	// set once the current run started to apply changes
+	applying bool
+	// only set if the audit log is enabled
+	audit *auditLog
+}
+
+func newRunner(config *Config) *runner {
+	netboxToken, err := readCredential("netbox_token", "NETBOX_TOKEN", config.Credentials.NetBoxToken)
+	handleError("Reading NetBox token", err)
+	zabbixUser, err := readCredential("zabbix_user", "ZABBIX_USER", config.Credentials.ZabbixUser)
+	handleError("Reading Zabbix user", err)
+	zabbixPassphrase, err := readCredential("zabbix_passphrase", "ZABBIX_PASSPHRASE", config.Credentials.ZabbixPassphrase)
+	handleError("Reading Zabbix passphrase", err)
+	zabbixToken, err := readCredential("zabbix_token", "ZABBIX_TOKEN", config.Credentials.ZabbixToken)
+	handleError("Reading Zabbix token", err)
+
+	if zabbixToken != "" && (zabbixUser != "" || zabbixPassphrase != "") {
+		Warn("Both a Zabbix API token and user credentials are set, using the token.")
+	}
 
 	if zabbixUser == "" {
 		zabbixUser = "guest"
 	}
 
-	z := zConnect(config.Zabbix, zabbixUser, zabbixPassphrase)
-	nb, nbctx := nbConnect(config.NetBox, netboxToken)
+	zabbixClient, err := newHTTPClient("Zabbix", config.Clients.Zabbix, isIdempotentZabbixRequest, zabbixEndpoint)
+	handleError("Configuring Zabbix HTTP client", err)
+	netboxClient, err := newHTTPClient("NetBox", config.Clients.NetBox, isIdempotentNetBoxRequest, netboxEndpoint)
+	handleError("Configuring NetBox HTTP client", err)
+
+	r := &runner{
+		config: config,
+		z:      zConnect(config.Zabbix, zabbixUser, zabbixPassphrase, zabbixToken, zabbixClient),
+		// sessions created by user.login stay active on the server until they expire
+		zabbixLogin: zabbixToken == "",
+	}
+
+	if config.Audit.File != "" {
+		r.audit, err = openAuditLog(config.Audit.File)
+		handleError("Opening audit log", err)
+
+		netboxClient.Transport = &auditTransport{
+			base: netboxClient.Transport,
+			log:  r.audit,
+		}
+	}
+
+	r.nb, r.nbctx = nbConnect(config.NetBox, netboxToken, netboxClient)
+
+	if config.Server.Listen != "" {
+		r.webhookToken, err = readCredential("webhook_token", "WEBHOOK_TOKEN", config.Credentials.WebhookToken)
+		handleError("Reading webhook token", err)
+	}
+
+	return r
+}
+
+func (r *runner) close() {
+	if r.zabbixLogin {
+		zLogout(r.z)
+	}
 
+	if r.audit != nil {
+		r.audit.close()
+	}
+}
+
+func (r *runner) run(dryRun bool, force bool, selection hostSelection) (zabbixHosts, error) {
 	zh := make(zabbixHosts)
-	prepare(z, &zh, config.HostGroups, limit)
-	sync(&zh, nb, nbctx, runDry, limit, config.Sync)
+	r.applying = false
+	// identifies the changes made by this run in the audit log
+	ctx := withAuditRun(r.nbctx, newRunID())
+	prepare(r.z, &zh, r.config, selection)
+
+	if !dryRun && !force && r.config.Safety.enabled() {
+		Info("Checking planned changes against safety thresholds")
+
+		plan := cloneHosts(&zh)
+		sync(&plan, r.nb, ctx, true, r.config.Sync)
+
+		violations := checkSafety(&plan, r.config.Safety)
+		if len(violations) > 0 {
+			for _, violation := range violations {
+				Error("Safety threshold exceeded: %s", violation)
+			}
+
+			return plan, errSafetyThresholds
+		}
+	}
+
+	r.applying = !dryRun
+	sync(&zh, r.nb, ctx, dryRun, r.config.Sync)
+	observeChanges(&zh, dryRun)
+
+	return zh, nil
 }
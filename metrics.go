@@ -0,0 +1,303 @@
+/*
+   Zabbix -> NetBox synchronization tool
+   Copyright (C) 2025  SUSE LLC <georg.pfuetzenreuter@suse.com>
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// minimal implementation of the Prometheus text exposition format
+
+const metricsPrefix = "zabbix_netbox_sync_"
+
+var apiDurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+type metricFamily struct {
+	help string
+	kind string
+	// keyed by the series name including labels
+	samples map[string]float64
+}
+
+var (
+	metricsRegistry = map[string]*metricFamily{
+		"run_duration_seconds":           {help: "Duration of the last sync run.", kind: "gauge"},
+		"runs_total":                     {help: "Sync runs by result.", kind: "counter"},
+		"last_success_timestamp_seconds": {help: "Time of the last successful sync run.", kind: "gauge"},
+		"hosts":                          {help: "Hosts considered in the last sync run by outcome and reason.", kind: "gauge"},
+		"objects_total":                  {help: "NetBox objects changed by object type and action.", kind: "counter"},
+		"api_requests_total":             {help: "API requests by API, endpoint and response code.", kind: "counter"},
+		"api_request_duration_seconds":   {help: "API request latency by API and endpoint.", kind: "histogram"},
+	}
+
+	// guards metricsRegistry, a channel is used as the sync package name is taken by sync()
+	metricsLock = make(chan struct{}, 1)
+
+	netboxIdPattern = regexp.MustCompile(`/\d+/`)
+)
+
+func formatLabels(pairs ...string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	labels := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		labels = append(labels, fmt.Sprintf("%s=%s", pairs[i], strconv.Quote(pairs[i+1])))
+	}
+
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+func updateMetric(name string, suffix string, labels string, update func(float64) float64) {
+	family := metricsRegistry[name]
+	if family.samples == nil {
+		family.samples = make(map[string]float64)
+	}
+
+	series := metricsPrefix + name + suffix + labels
+	family.samples[series] = update(family.samples[series])
+}
+
+func setMetric(name string, value float64, labels ...string) {
+	metricsLock <- struct{}{}
+	defer func() { <-metricsLock }()
+
+	updateMetric(name, "", formatLabels(labels...), func(float64) float64 { return value })
+}
+
+func addMetric(name string, value float64, labels ...string) {
+	metricsLock <- struct{}{}
+	defer func() { <-metricsLock }()
+
+	updateMetric(name, "", formatLabels(labels...), func(old float64) float64 { return old + value })
+}
+
+func resetMetric(name string) {
+	metricsLock <- struct{}{}
+	defer func() { <-metricsLock }()
+
+	metricsRegistry[name].samples = nil
+}
+
+func observeMetric(name string, value float64, labels ...string) {
+	metricsLock <- struct{}{}
+	defer func() { <-metricsLock }()
+
+	increment := func(old float64) float64 { return old + 1 }
+
+	for _, bucket := range apiDurationBuckets {
+		if value <= bucket {
+			updateMetric(name, "_bucket", formatLabels(append(labels, "le", strconv.FormatFloat(bucket, 'f', -1, 64))...), increment)
+		} else {
+			// buckets are cumulative, hence all of them need to exist even if they were never hit
+			updateMetric(name, "_bucket", formatLabels(append(labels, "le", strconv.FormatFloat(bucket, 'f', -1, 64))...), func(old float64) float64 { return old })
+		}
+	}
+
+	updateMetric(name, "_bucket", formatLabels(append(labels, "le", "+Inf")...), increment)
+	updateMetric(name, "_sum", formatLabels(labels...), func(old float64) float64 { return old + value })
+	updateMetric(name, "_count", formatLabels(labels...), increment)
+}
+
+func writeMetrics(w io.Writer) error {
+	metricsLock <- struct{}{}
+	defer func() { <-metricsLock }()
+
+	names := make([]string, 0, len(metricsRegistry))
+	for name := range metricsRegistry {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var buffer bytes.Buffer
+
+	for _, name := range names {
+		family := metricsRegistry[name]
+		if len(family.samples) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(&buffer, "# HELP %s%s %s\n", metricsPrefix, name, family.help)
+		fmt.Fprintf(&buffer, "# TYPE %s%s %s\n", metricsPrefix, name, family.kind)
+
+		series := make([]string, 0, len(family.samples))
+		for s := range family.samples {
+			series = append(series, s)
+		}
+		slices.Sort(series)
+
+		for _, s := range series {
+			fmt.Fprintf(&buffer, "%s %s\n", s, strconv.FormatFloat(family.samples[s], 'g', -1, 64))
+		}
+	}
+
+	_, err := w.Write(buffer.Bytes())
+
+	return err
+}
+
+// writes to a temporary file first, to avoid the node_exporter textfile collector reading incomplete data
+func writeMetricsFile(path string) {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		Error("Creating metrics file failed: %s", err)
+		return
+	}
+
+	err = writeMetrics(file)
+	if err == nil {
+		err = file.Chmod(0644)
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+
+	if err != nil {
+		Error("Writing metrics file failed: %s", err)
+		os.Remove(file.Name())
+	}
+}
+
+func handleMetrics(w http.ResponseWriter, request *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	err := writeMetrics(w)
+	if err != nil {
+		Warn("Writing metrics response failed: %s", err)
+	}
+}
+
+// counts changed objects, multiple changed fields of the same object count as one update
+func observeChanges(zh *zabbixHosts, dryRun bool) {
+	if dryRun {
+		return
+	}
+
+	for _, host := range *zh {
+		updated := make(map[string]bool)
+
+		for _, c := range significantChanges(host) {
+			if c.Action == "update" {
+				key := fmt.Sprintf("%s:%d", c.Object, c.ID)
+				if updated[key] {
+					continue
+				}
+				updated[key] = true
+			}
+
+			addMetric("objects_total", 1, "type", c.Object, "action", c.Action)
+		}
+	}
+}
+
+func observeRun(zh *zabbixHosts, start time.Time, err error) {
+	setMetric("run_duration_seconds", time.Since(start).Seconds())
+
+	if err != nil {
+		addMetric("runs_total", 1, "result", "failure")
+		return
+	}
+
+	addMetric("runs_total", 1, "result", "success")
+	setMetric("last_success_timestamp_seconds", float64(time.Now().Unix()))
+
+	resetMetric("hosts")
+	for _, host := range *zh {
+		if !host.Scanned {
+			continue
+		}
+
+		outcome := hostOutcome(host)
+
+		if len(host.Reasons) == 0 {
+			addMetric("hosts", 1, "outcome", outcome, "reason", "")
+			continue
+		}
+
+		for _, reason := range host.Reasons {
+			addMetric("hosts", 1, "outcome", outcome, "reason", reason)
+		}
+	}
+}
+
+// records every request attempt, hence it is placed below the retry transport
+type metricsTransport struct {
+	base     http.RoundTripper
+	api      string
+	endpoint func(request *http.Request) string
+}
+
+func (t *metricsTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	endpoint := t.endpoint(request)
+	start := time.Now()
+
+	response, err := t.base.RoundTrip(request)
+
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(response.StatusCode)
+	}
+
+	addMetric("api_requests_total", 1, "api", t.api, "endpoint", endpoint, "code", code)
+	observeMetric("api_request_duration_seconds", time.Since(start).Seconds(), "api", t.api, "endpoint", endpoint)
+
+	return response, err
+}
+
+// replaces object IDs to keep the number of series low
+func netboxEndpoint(request *http.Request) string {
+	return request.Method + " " + netboxIdPattern.ReplaceAllString(request.URL.Path, "/{id}/")
+}
+
+func zabbixEndpoint(request *http.Request) string {
+	if request.GetBody == nil {
+		return "unknown"
+	}
+
+	body, err := request.GetBody()
+	if err != nil {
+		return "unknown"
+	}
+	defer body.Close()
+
+	var rpc struct {
+		Method string `json:"method"`
+	}
+
+	if err := json.NewDecoder(body).Decode(&rpc); err != nil || rpc.Method == "" {
+		return "unknown"
+	}
+
+	return rpc.Method
+}
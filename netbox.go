@@ -22,8 +22,8 @@ import (
 	"context"
 	"encoding/json"
 	"github.com/netbox-community/go-netbox/v4"
+	"log/slog"
 	"net/http"
-	"os"
 )
 
 type site struct {
@@ -32,15 +32,23 @@ type site struct {
 	Domain string
 }
 
-func nbConnect(url string, token string) (*netbox.APIClient, context.Context) {
-	return netbox.NewAPIClientFor(url, token), context.Background()
+// POST creates objects, everything else can be repeated without side effects
+func isIdempotentNetBoxRequest(request *http.Request, body []byte) bool {
+	return request.Method != http.MethodPost
+}
+
+func nbConnect(url string, token string, client *http.Client) (*netbox.APIClient, context.Context) {
+	nb := netbox.NewAPIClientFor(url, token)
+	nb.GetConfig().HTTPClient = client
+
+	return nb, context.Background()
 }
 
 func getVirtualMachines(nb *netbox.APIClient, ctx context.Context) *netbox.PaginatedVirtualMachineWithConfigContextList {
 	result, _, err := nb.VirtualizationAPI.VirtualizationVirtualMachinesList(ctx).Execute()
 	handleError("Querying virtual machines", err)
 
-	Debug("getVirtualMachines() returns %v", result.Results)
+	logger.Debug("Queried virtual machines", "objects", result.Results)
 
 	return result
 }
@@ -49,7 +57,7 @@ func getDevices(nb *netbox.APIClient, ctx context.Context) *netbox.PaginatedDevi
 	result, _, err := nb.DcimAPI.DcimDevicesList(ctx).Execute()
 	handleError("Querying devices", err)
 
-	Debug("getDevices() returns %v", result.Results)
+	logger.Debug("Queried devices", "objects", result.Results)
 
 	return result
 }
@@ -58,21 +66,17 @@ func getSites(nb *netbox.APIClient, ctx context.Context) []site {
 	result, _, err := nb.DcimAPI.DcimSitesList(ctx).Execute()
 	handleError("Querying sites", err)
 
-	Debug("getSites() returns %v", result.Results)
+	logger.Debug("Queried sites", "objects", result.Results)
 
 	var sites []site
 
 	for _, object := range result.Results {
-		Debug("Processing site %+v", object)
+		logger.Debug("Processing site", "object", "dcim.site", "id", object.Id, "site", object.Slug)
 
+		// sites without a domain can only be selected through the metadata
 		var domain string
-		var has_domain bool
 		if value, ok := object.CustomFields["domain"]; ok {
-			domain, has_domain = value.(string)
-		}
-
-		if !has_domain {
-			continue
+			domain, _ = value.(string)
 		}
 
 		sites = append(sites, site{
@@ -85,32 +89,37 @@ func getSites(nb *netbox.APIClient, ctx context.Context) []site {
 	return sites
 }
 
-func handleResponse(created interface{}, response *http.Response, err error) {
+func handleResponse(log *slog.Logger, created interface{}, response *http.Response, err error) {
 	if err != nil {
-		Error("API returned: %s", err)
+		log.Error("API request failed", "error", err)
 	}
 
-	var body interface{}
-	jerr := json.NewDecoder(response.Body).Decode(&body)
-	handleError("Decoding response body", jerr)
-
-	if body != nil {
-		if err == nil {
-			Debug("%+v", body)
-		} else {
-			Error("%+v", body)
+	// there is no response if the request could not be sent
+	if response != nil {
+		var body interface{}
+		jerr := json.NewDecoder(response.Body).Decode(&body)
+		handleError("Decoding response body", jerr)
+
+		if body != nil {
+			if err == nil {
+				log.Debug("API response", "body", body)
+			} else {
+				log.Error("API response", "body", body, "status", response.StatusCode)
+			}
 		}
 	}
 
-	if err != nil || jerr != nil {
-		os.Exit(1)
+	if err != nil {
+		// Fatal() only aborts the current run in daemon mode
+		Fatal("NetBox API request failed: %s", err)
 	}
 
-	Debug("Returned object: %+v", created)
+	log.Debug("Returned object", "result", created)
 }
 
-func assignMacAddress(nb *netbox.APIClient, ctx context.Context, objid int32, address string, aobjtype string, aobjid int64) {
-	Info("Assigning MAC address object %d to %s object %d", objid, aobjtype, aobjid)
+func assignMacAddress(log *slog.Logger, nb *netbox.APIClient, ctx context.Context, objid int32, address string, aobjtype string, aobjid int64) {
+	log = log.With("object", "dcim.macaddress", "id", objid)
+	log.Info("Assigning MAC address object", "address", address, "assigned_object_type", aobjtype, "assigned_object_id", aobjid)
 
 	request := netbox.PatchedMACAddressRequest{
 		MacAddress:         &address,
@@ -118,14 +127,15 @@ func assignMacAddress(nb *netbox.APIClient, ctx context.Context, objid int32, ad
 		AssignedObjectId:   *netbox.NewNullableInt64(&aobjid),
 	}
 
-	Debug("Payload: %+v", request)
+	log.Debug("Payload", "payload", request)
 
 	created, response, rerr := nb.DcimAPI.DcimMacAddressesPartialUpdate(ctx, objid).PatchedMACAddressRequest(request).Execute()
-	handleResponse(created, response, rerr)
+	handleResponse(log, created, response, rerr)
 }
 
-func assignIpAddress(nb *netbox.APIClient, ctx context.Context, objid int32, address string, aobjtype string, aobjid int64) {
-	Info("Assigning IP address object %d to %s object %d", objid, aobjtype, aobjid)
+func assignIpAddress(log *slog.Logger, nb *netbox.APIClient, ctx context.Context, objid int32, address string, aobjtype string, aobjid int64) {
+	log = log.With("object", "ipam.ipaddress", "id", objid)
+	log.Info("Assigning IP address object", "address", address, "assigned_object_type", aobjtype, "assigned_object_id", aobjid)
 
 	request := netbox.PatchedWritableIPAddressRequest{
 		Address:            &address,
@@ -133,8 +143,8 @@ func assignIpAddress(nb *netbox.APIClient, ctx context.Context, objid int32, add
 		AssignedObjectId:   *netbox.NewNullableInt64(&aobjid),
 	}
 
-	Debug("Payload: %+v", request)
+	log.Debug("Payload", "payload", request)
 
 	created, response, rerr := nb.IpamAPI.IpamIpAddressesPartialUpdate(ctx, objid).PatchedWritableIPAddressRequest(request).Execute()
-	handleResponse(created, response, rerr)
+	handleResponse(log, created, response, rerr)
 }
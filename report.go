@@ -0,0 +1,215 @@
+/*
+   Zabbix -> NetBox synchronization tool
+   Copyright (C) 2025  SUSE LLC <georg.pfuetzenreuter@suse.com>
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+type reportEntry struct {
+	Host    string   `json:"host"`
+	HostID  string   `json:"hostid"`
+	Type    string   `json:"type"`
+	Site    string   `json:"site"`
+	Outcome string   `json:"outcome"`
+	Changed []string `json:"changed"`
+	Reasons []string `json:"reasons"`
+}
+
+type report struct {
+	Dry   bool          `json:"dry"`
+	Hosts []reportEntry `json:"hosts"`
+}
+
+func hostOutcome(host *zabbixHostData) string {
+	if host.Error {
+		return "error"
+	}
+
+	if !host.Processed {
+		return "skipped"
+	}
+
+	outcome := "unchanged"
+
+	for _, c := range significantChanges(host) {
+		if c.Action == "create" && (c.Object == "dcim.device" || c.Object == "virtualization.virtualmachine") {
+			return "created"
+		}
+
+		outcome = "updated"
+	}
+
+	return outcome
+}
+
+// device and virtual machine fields are listed by their name, fields of related objects are prefixed with the object type
+func changedFields(host *zabbixHostData) []string {
+	fields := []string{}
+
+	for _, c := range significantChanges(host) {
+		var field string
+
+		object := c.Object[strings.LastIndex(c.Object, ".")+1:]
+
+		switch {
+		case c.Object == "dcim.device" || c.Object == "virtualization.virtualmachine":
+			field = c.Field
+			if c.Action == "create" {
+				field = "created"
+			}
+		case c.Action == "update":
+			field = object + "." + c.Field
+		default:
+			field = object + "." + c.Action
+		}
+
+		if !contains(fields, field) {
+			fields = append(fields, field)
+		}
+	}
+
+	return fields
+}
+
+func buildReport(zh *zabbixHosts, dryRun bool) report {
+	r := report{
+		Dry:   dryRun,
+		Hosts: []reportEntry{},
+	}
+
+	for _, host := range *zh {
+		if !host.Scanned {
+			continue
+		}
+
+		entry := reportEntry{
+			Host:    host.HostName,
+			HostID:  host.HostID,
+			Site:    host.Site,
+			Outcome: hostOutcome(host),
+			Changed: changedFields(host),
+			Reasons: host.Reasons,
+		}
+
+		switch host.ObjType {
+		case "Physical":
+			entry.Type = "device"
+		case "Virtual":
+			entry.Type = "vm"
+		}
+
+		if entry.Reasons == nil {
+			entry.Reasons = []string{}
+		}
+
+		if entry.Outcome == "skipped" && len(entry.Reasons) == 0 {
+			// the run was interrupted before the host was processed
+			entry.Reasons = []string{"not_processed"}
+		}
+
+		r.Hosts = append(r.Hosts, entry)
+	}
+
+	slices.SortFunc(r.Hosts, func(a, b reportEntry) int {
+		return strings.Compare(a.Host, b.Host)
+	})
+
+	return r
+}
+
+func writeReportTable(w io.Writer, r report) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "HOST\tID\tTYPE\tSITE\tOUTCOME\tCHANGED\tREASONS")
+
+	counts := make(map[string]int)
+
+	for _, entry := range r.Hosts {
+		counts[entry.Outcome]++
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			entry.Host, entry.HostID, valueOrDash(entry.Type), valueOrDash(entry.Site), entry.Outcome,
+			valueOrDash(strings.Join(entry.Changed, ",")), valueOrDash(strings.Join(entry.Reasons, ",")))
+	}
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	mode := "wet"
+	if r.Dry {
+		mode = "dry"
+	}
+
+	_, err := fmt.Fprintf(w, "\n%d hosts (%s run): %d created, %d updated, %d unchanged, %d skipped, %d errors\n",
+		len(r.Hosts), mode, counts["created"], counts["updated"], counts["unchanged"], counts["skipped"], counts["error"])
+
+	return err
+}
+
+func valueOrDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+
+	return value
+}
+
+func writeReport(zh *zabbixHosts, options runOptions) {
+	if options.reportFormat == "none" {
+		return
+	}
+
+	r := buildReport(zh, options.dryRun)
+
+	var w io.Writer = os.Stdout
+
+	if options.reportFile != "" {
+		file, err := os.Create(options.reportFile)
+		if err != nil {
+			Error("Creating report file failed: %s", err)
+			return
+		}
+		defer file.Close()
+
+		w = file
+	}
+
+	var err error
+
+	switch options.reportFormat {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(r)
+	case "table":
+		err = writeReportTable(w, r)
+	}
+
+	if err != nil {
+		Error("Writing report failed: %s", err)
+	}
+}
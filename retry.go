@@ -0,0 +1,191 @@
+/*
+   Zabbix -> NetBox synchronization tool
+   Copyright (C) 2025  SUSE LLC <georg.pfuetzenreuter@suse.com>
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// decides whether a request can safely be sent multiple times, the body is passed separately as the request body can only be read once
+type idempotencyFunc func(request *http.Request, body []byte) bool
+
+// retries failed requests with exponential backoff and enforces the per-attempt timeout
+type retryTransport struct {
+	base       http.RoundTripper
+	name       string
+	config     RetryConfig
+	timeout    time.Duration
+	idempotent idempotencyFunc
+}
+
+// cancels the per-attempt timeout context once the caller is done reading the response
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+
+	return err
+}
+
+// connection errors before the request was sent are safe to retry regardless of the idempotency
+func isDialError(err error) bool {
+	var opErr *net.OpError
+
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+
+	return 0
+}
+
+func (t *retryTransport) backoff(attempt int) time.Duration {
+	delay := t.config.MinDelay << (attempt - 1)
+	if delay > t.config.MaxDelay || delay <= 0 {
+		delay = t.config.MaxDelay
+	}
+
+	// equal jitter, to avoid all clients retrying at the same time while still growing the delay
+	half := int64(delay / 2)
+	if half <= 0 {
+		return delay
+	}
+
+	return time.Duration(half + rand.Int64N(half))
+}
+
+func (t *retryTransport) attempt(request *http.Request, body []byte) (*http.Response, error) {
+	ctx := request.Context()
+	cancel := context.CancelFunc(func() {})
+	if t.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, t.timeout)
+	}
+
+	attemptRequest := request.Clone(ctx)
+	if body != nil {
+		attemptRequest.Body = io.NopCloser(bytes.NewReader(body))
+		attemptRequest.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+
+	response, err := t.base.RoundTrip(attemptRequest)
+	if err != nil {
+		cancel()
+
+		return nil, err
+	}
+
+	response.Body = &cancelBody{ReadCloser: response.Body, cancel: cancel}
+
+	return response, nil
+}
+
+func (t *retryTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	var body []byte
+	if request.Body != nil && request.Body != http.NoBody {
+		var err error
+		body, err = io.ReadAll(request.Body)
+		request.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	idempotent := t.idempotent(request, body)
+
+	for attempt := 1; ; attempt++ {
+		response, err := t.attempt(request, body)
+
+		var reason string
+		var retryAfter time.Duration
+
+		switch {
+		case err != nil:
+			if !idempotent && !isDialError(err) {
+				return nil, err
+			}
+			reason = err.Error()
+
+		case response.StatusCode == http.StatusTooManyRequests:
+			// the server refused to process the request, hence this is safe to retry regardless of the idempotency
+			reason = response.Status
+			retryAfter = parseRetryAfter(response.Header.Get("Retry-After"))
+
+		case response.StatusCode >= 500 && idempotent:
+			reason = response.Status
+			retryAfter = parseRetryAfter(response.Header.Get("Retry-After"))
+
+		default:
+			return response, nil
+		}
+
+		if attempt >= t.config.Attempts || request.Context().Err() != nil {
+			Debug("Giving up on %s request %s %s after %d attempts: %s", t.name, request.Method, request.URL.Redacted(), attempt, reason)
+
+			return response, err
+		}
+
+		if retryAfter > t.config.MaxDelay {
+			Warn("%s asks to retry request %s %s after %s, which exceeds the maximum delay.", t.name, request.Method, request.URL.Redacted(), retryAfter)
+
+			return response, err
+		}
+
+		delay := max(t.backoff(attempt), retryAfter)
+
+		Warn("%s request %s %s failed: %s, retrying in %s (attempt %d/%d)", t.name, request.Method, request.URL.Redacted(), reason, delay.Round(time.Millisecond), attempt, t.config.Attempts)
+
+		if response != nil {
+			// drain the body to allow reuse of the connection
+			io.Copy(io.Discard, response.Body)
+			response.Body.Close()
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-request.Context().Done():
+			return nil, fmt.Errorf("%s request aborted while waiting for retry: %w", t.name, request.Context().Err())
+		}
+	}
+}
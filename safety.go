@@ -0,0 +1,166 @@
+/*
+   Zabbix -> NetBox synchronization tool
+   Copyright (C) 2025  SUSE LLC <georg.pfuetzenreuter@suse.com>
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var errSafetyThresholds = errors.New("Safety thresholds exceeded, not applying any changes. Use -force to apply them regardless.")
+
+// either an absolute number of hosts or a percentage of the hosts synced in the run
+type SafetyThreshold struct {
+	set     bool
+	value   float64
+	percent bool
+}
+
+func (t *SafetyThreshold) UnmarshalYAML(node *yaml.Node) error {
+	value, percent := strings.CutSuffix(strings.TrimSpace(node.Value), "%")
+
+	number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || number < 0 || (!percent && number != math.Trunc(number)) {
+		return fmt.Errorf("Invalid safety threshold '%s', use a positive number of hosts or a percentage such as '5%%'.", node.Value)
+	}
+
+	*t = SafetyThreshold{set: true, value: number, percent: percent}
+
+	return nil
+}
+
+func (t SafetyThreshold) String() string {
+	if t.percent {
+		return strconv.FormatFloat(t.value, 'f', -1, 64) + "%"
+	}
+
+	return strconv.FormatFloat(t.value, 'f', -1, 64)
+}
+
+func (t SafetyThreshold) exceeded(count int, total int) bool {
+	if !t.set {
+		return false
+	}
+
+	if t.percent {
+		return total > 0 && float64(count)*100/float64(total) > t.value
+	}
+
+	return float64(count) > t.value
+}
+
+type SafetyConfig struct {
+	MaxCreates       SafetyThreshold `yaml:"max_creates"`
+	MaxUpdates       SafetyThreshold `yaml:"max_updates"`
+	MaxSiteChanges   SafetyThreshold `yaml:"max_site_changes"`
+	MaxDecommissions SafetyThreshold `yaml:"max_decommissions"`
+}
+
+func (c SafetyConfig) enabled() bool {
+	return c.MaxCreates.set || c.MaxUpdates.set || c.MaxSiteChanges.set || c.MaxDecommissions.set
+}
+
+// copies the hosts for a dry run, without sharing the slices modified by sync()
+func cloneHosts(zh *zabbixHosts) zabbixHosts {
+	clone := make(zabbixHosts, len(*zh))
+
+	for id, host := range *zh {
+		copied := *host
+		copied.Changes = nil
+		copied.Reasons = slices.Clone(host.Reasons)
+		clone[id] = &copied
+	}
+
+	return clone
+}
+
+// counts the hosts affected by each kind of change and returns a message for every exceeded threshold
+func checkSafety(zh *zabbixHosts, config SafetyConfig) []string {
+	var total, created, updated, resited, decommissioned int
+
+	for _, host := range *zh {
+		// hosts outside the selection, skipped or failing are not synced and do not count towards percentages
+		if !host.Scanned || host.Skipped || (host.Error && !hasOnlyItemErrors(host)) {
+			continue
+		}
+
+		total++
+
+		var isCreated, isUpdated, isResited, isDecommissioned bool
+
+		for _, c := range host.Changes {
+			isHostObject := c.Object == "dcim.device" || c.Object == "virtualization.virtualmachine"
+
+			switch {
+			case isHostObject && c.Action == "create":
+				isCreated = true
+			case isHostObject && c.Field == "site":
+				isResited = true
+				isUpdated = true
+			case isHostObject && c.Field == "status" && c.New != "active":
+				isDecommissioned = true
+				isUpdated = true
+			case !isHostObject && (c.Action == "create" || c.Action == "assign"), c.Action == "update" && !c.routine:
+				isUpdated = true
+			}
+		}
+
+		// changes to related objects of a new device or virtual machine are part of creating it
+		if isCreated {
+			created++
+			continue
+		}
+
+		if isUpdated {
+			updated++
+		}
+
+		if isResited {
+			resited++
+		}
+
+		if isDecommissioned {
+			decommissioned++
+		}
+	}
+
+	violations := []string{}
+
+	for _, check := range []struct {
+		name      string
+		count     int
+		threshold SafetyThreshold
+	}{
+		{"created", created, config.MaxCreates},
+		{"updated", updated, config.MaxUpdates},
+		{"moved to a different site", resited, config.MaxSiteChanges},
+		{"decommissioned", decommissioned, config.MaxDecommissions},
+	} {
+		if check.threshold.exceeded(check.count, total) {
+			violations = append(violations, fmt.Sprintf("%d of %d hosts would be %s, the limit is %s", check.count, total, check.name, check.threshold))
+		}
+	}
+
+	return violations
+}
@@ -0,0 +1,196 @@
+/*
+   Zabbix -> NetBox synchronization tool
+   Copyright (C) 2025  SUSE LLC <georg.pfuetzenreuter@suse.com>
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"github.com/fabiang/go-zabbix"
+	"regexp"
+	"strings"
+)
+
+// selectors are "id:<host ID>", "group:<host group pattern>", "re:<expression>" or a host name pattern
+type hostSelection struct {
+	// empty selects all hosts
+	limit   []string
+	exclude []string
+	// restricts the Zabbix queries to the given host IDs in addition to the limit, for example to a host ID and a name
+	ids []string
+}
+
+// a flag which can be repeated and takes comma separated values
+type listFlag []string
+
+func (f *listFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *listFlag) Set(value string) error {
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			*f = append(*f, entry)
+		}
+	}
+
+	return nil
+}
+
+func validateSelectors(selectors []string) error {
+	for _, selector := range selectors {
+		if expression, ok := strings.CutPrefix(selector, "re:"); ok {
+			if _, err := regexp.Compile(expression); err != nil {
+				return fmt.Errorf("Invalid host selector '%s': %s", selector, err)
+			}
+		}
+
+		if pattern, ok := strings.CutPrefix(selector, "group:"); ok {
+			if _, err := compileHostGroupExpressions([]string{pattern}); err != nil {
+				return err
+			}
+		}
+
+		if id, ok := strings.CutPrefix(selector, "id:"); ok && id == "" {
+			return fmt.Errorf("Invalid host selector '%s', the host ID is missing.", selector)
+		}
+	}
+
+	return nil
+}
+
+// returns the host IDs if all selectors are host IDs, which allows to restrict the Zabbix queries
+func selectorIds(selectors []string) []string {
+	ids := make([]string, 0, len(selectors))
+
+	for _, selector := range selectors {
+		id, ok := strings.CutPrefix(selector, "id:")
+		if !ok {
+			return nil
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+type hostMatcher struct {
+	selectors []string
+	// compiled "re:" selectors, keyed by the selector
+	expressions map[string]*regexp.Regexp
+	// IDs of the hosts in the groups named by "group:" selectors
+	groupHostIds []string
+}
+
+func newHostMatcher(z *zabbix.Session, hostGroups []zabbix.Hostgroup, selectors []string, subgroups bool, hostIds []string) *hostMatcher {
+	matcher := &hostMatcher{selectors: selectors, expressions: make(map[string]*regexp.Regexp)}
+
+	var patterns []string
+	for _, selector := range selectors {
+		if pattern, ok := strings.CutPrefix(selector, "group:"); ok {
+			patterns = append(patterns, pattern)
+		}
+
+		if expression, ok := strings.CutPrefix(selector, "re:"); ok {
+			// validated when parsing the flags and the configuration
+			matcher.expressions[selector] = regexp.MustCompile(expression)
+		}
+	}
+
+	if len(patterns) == 0 {
+		return matcher
+	}
+
+	// validated when parsing the flags and the configuration
+	expressions, _ := compileHostGroupExpressions(patterns)
+
+	groupIds := filterHostGroupIds(hostGroups, patterns, subgroups, expressions, "Selected")
+	for _, h := range getHostsInGroups(z, groupIds, hostIds) {
+		matcher.groupHostIds = append(matcher.groupHostIds, h.HostID)
+	}
+
+	return matcher
+}
+
+// names are matched against the Zabbix host name, the visible name and the DNS names of the host interfaces
+func (m *hostMatcher) matches(id string, names []string) bool {
+	for _, selector := range m.selectors {
+		if value, ok := strings.CutPrefix(selector, "id:"); ok {
+			if value == id {
+				return true
+			}
+
+			continue
+		}
+
+		if _, ok := strings.CutPrefix(selector, "group:"); ok {
+			if contains(m.groupHostIds, id) {
+				return true
+			}
+
+			continue
+		}
+
+		for _, name := range names {
+			if name == "" {
+				continue
+			}
+
+			if expression, ok := m.expressions[selector]; ok {
+				if expression.MatchString(name) {
+					return true
+				}
+			} else if selector == name || matchPattern(selector, name) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// marks the selected hosts as scanned
+func selectHosts(zh *zabbixHosts, workHosts []zabbix.Host, limit *hostMatcher, exclude *hostMatcher) {
+	names := make(map[string][]string, len(workHosts))
+	for _, h := range workHosts {
+		names[h.HostID] = []string{h.Hostname, h.DisplayName}
+	}
+
+	selectedIds := []string{}
+
+	for id, host := range *zh {
+		hostNames := append(names[id], host.HostName)
+		for _, iface := range host.HostInterfaces {
+			hostNames = append(hostNames, iface.DNS)
+		}
+
+		if len(limit.selectors) > 0 && !limit.matches(id, hostNames) {
+			continue
+		}
+
+		if exclude.matches(id, hostNames) {
+			host.log.Debug("Excluding host by selector")
+			continue
+		}
+
+		host.Scanned = true
+		selectedIds = append(selectedIds, id)
+	}
+
+	logger.Info("Selected hosts", "count", len(selectedIds), "total", len(*zh))
+}
@@ -0,0 +1,218 @@
+/*
+   Zabbix -> NetBox synchronization tool
+   Copyright (C) 2025  SUSE LLC <georg.pfuetzenreuter@suse.com>
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type webhookRequest struct {
+	Host   string `json:"host"`
+	HostID string `json:"hostid"`
+	// allows to preview the changes of a wet daemon, a dry daemon cannot be made wet
+	Dry bool `json:"dry"`
+}
+
+type webhookHostResult struct {
+	Host    string   `json:"host"`
+	HostID  string   `json:"hostid"`
+	Changes []change `json:"changes"`
+	Error   string   `json:"error,omitempty"`
+}
+
+type webhookResponse struct {
+	Dry   bool                `json:"dry"`
+	Hosts []webhookHostResult `json:"hosts"`
+	Error string              `json:"error,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		Warn("Writing webhook response failed: %s", err)
+	}
+}
+
+func (d *daemonState) authorized(request *http.Request) bool {
+	token := *d.webhookToken.Load()
+	given, ok := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer ")
+
+	return ok && token != "" && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+func (d *daemonState) handleSync(dryRun bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSON(w, http.StatusMethodNotAllowed, webhookResponse{Error: "Only POST is supported"})
+			return
+		}
+
+		if !d.authorized(request) {
+			Warn("Rejected unauthorized webhook request from %s", request.RemoteAddr)
+			writeJSON(w, http.StatusUnauthorized, webhookResponse{Error: "Unauthorized"})
+			return
+		}
+
+		var body webhookRequest
+		decoder := json.NewDecoder(http.MaxBytesReader(w, request.Body, 1<<16))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&body); err != nil {
+			writeJSON(w, http.StatusBadRequest, webhookResponse{Error: "Invalid request body: " + err.Error()})
+			return
+		}
+
+		if body.Host == "" && body.HostID == "" {
+			writeJSON(w, http.StatusBadRequest, webhookResponse{Error: "Specify 'host', 'hostid' or both"})
+			return
+		}
+
+		response := webhookResponse{
+			Dry:   dryRun || body.Dry,
+			Hosts: []webhookHostResult{},
+		}
+
+		var selection hostSelection
+		if body.HostID != "" {
+			// the host ID also restricts the Zabbix queries if a name is given, only a host matching both is synced
+			selection.limit = []string{"id:" + body.HostID}
+			selection.ids = []string{body.HostID}
+		}
+		if body.Host != "" {
+			// matched literally, as the webhook syncs a single host
+			selection.limit = []string{"re:^" + regexp.QuoteMeta(body.Host) + "$"}
+		}
+
+		Info("Webhook requested sync of host '%s' (ID '%s') from %s", body.Host, body.HostID, request.RemoteAddr)
+
+		r := d.acquire()
+		defer d.release()
+
+		if shutdownRequested.Load() {
+			writeJSON(w, http.StatusServiceUnavailable, webhookResponse{Error: "Shutting down"})
+			return
+		}
+
+		var zh zabbixHosts
+		var err error
+		if fatal := catchFatal(func() {
+			// safety thresholds guard against mass changes, which a single host cannot cause
+			zh, err = r.run(response.Dry, true, selection)
+		}); fatal != nil {
+			err = fatal
+		}
+		if errors.Is(err, errNoHosts) {
+			response.Error = "No matching host found"
+			writeJSON(w, http.StatusNotFound, response)
+			return
+		}
+
+		if err != nil {
+			response.Error = err.Error()
+			writeJSON(w, http.StatusInternalServerError, response)
+			return
+		}
+
+		for _, host := range zh {
+			if !host.Scanned {
+				continue
+			}
+
+			result := webhookHostResult{
+				Host:    host.HostName,
+				HostID:  host.HostID,
+				Changes: host.Changes,
+			}
+
+			if result.Changes == nil {
+				result.Changes = []change{}
+			}
+
+			if host.Error {
+				result.Error = "The host could not be synced due to errors in its Zabbix data, see the log for details"
+			}
+
+			response.Hosts = append(response.Hosts, result)
+		}
+
+		if len(response.Hosts) == 0 {
+			response.Error = "No matching host found"
+			writeJSON(w, http.StatusNotFound, response)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, response)
+	}
+}
+
+func startServer(config ServerConfig, state *daemonState, dryRun bool) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", handleMetrics)
+
+	if *state.webhookToken.Load() == "" {
+		Warn("No webhook token configured, the /sync endpoint is disabled.")
+	}
+	// the endpoint is registered regardless, as the token might be added by reloading the configuration
+	mux.Handle("/sync", state.handleSync(dryRun))
+
+	server := &http.Server{
+		Addr:              config.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	// listening before starting the goroutine allows to fail early, for example if the address is already in use
+	listener, err := net.Listen("tcp", config.Listen)
+	handleError("Starting webhook server", err)
+
+	Info("Webhook server listening on %s", listener.Addr())
+
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			// not using Fatal() as it panics in daemon mode, which cannot be recovered in this goroutine
+			Error("Webhook server failed: %s", err)
+			os.Exit(1)
+		}
+	}()
+
+	return server
+}
+
+func stopServer(server *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	err := server.Shutdown(ctx)
+	if err != nil {
+		Warn("Stopping the webhook server failed: %s", err)
+	}
+}
@@ -0,0 +1,82 @@
+/*
+   Zabbix -> NetBox synchronization tool
+   Copyright (C) 2025  SUSE LLC <georg.pfuetzenreuter@suse.com>
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+// SNMP hosts do not run the agent, hence their data is read from the items of SNMP templates
+type SNMPConfig struct {
+	// NetBox device role of SNMP hosts
+	Role  string          `yaml:"role"`
+	Items SNMPItemsConfig `yaml:"items"`
+}
+
+type SNMPItemsConfig struct {
+	Manufacturer string `yaml:"manufacturer"`
+	Model        string `yaml:"model"`
+	Serial       string `yaml:"serial"`
+}
+
+func (c SNMPItemsConfig) keys() []string {
+	return []string{c.Manufacturer, c.Model, c.Serial}
+}
+
+// SNMP hosts are always synced as devices
+func scanSNMPHost(host *zabbixHostData, config SyncConfig) bool {
+	items := config.SNMP.Items
+
+	for _, metric := range host.Metrics {
+		host.log.Debug("Processing item", "itemid", metric.ID, "item", metric.Key, "value", metric.Value)
+
+		switch metric.Key {
+
+		case items.Manufacturer:
+			host.Manufacturer = metric.Value
+
+		case items.Model:
+			host.Model = metric.Value
+
+		case items.Serial:
+			host.Serial = metric.Value
+
+		}
+	}
+
+	applyInventory(host, config.Inventory)
+	mergeMacros(host, config.Macros)
+	scanHostMetadata(host)
+
+	host.ObjType = "Physical"
+
+	if host.Manufacturer == "" {
+		host.log.Error("Host is missing a manufacturer", missingValueSources(config.Inventory, "manufacturer", items.Manufacturer)...)
+		host.Reasons = append(host.Reasons, "missing_manufacturer")
+	}
+
+	if host.Serial == "" {
+		host.log.Warn("Host is missing a serial number", missingValueSources(config.Inventory, "serial", items.Serial)...)
+		host.Reasons = append(host.Reasons, "missing_serial")
+	}
+
+	if host.Manufacturer == "" || host.Serial == "" {
+		host.Error = true
+
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,306 @@
+/*
+   Zabbix -> NetBox synchronization tool
+   Copyright (C) 2025  SUSE LLC <georg.pfuetzenreuter@suse.com>
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"github.com/netbox-community/go-netbox/v4"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// marks devices and virtual machines whose status was set by this tool, only those statuses are reverted
+const (
+	statusTagName = "zabbix-netbox-sync status"
+	statusTagSlug = "zabbix-netbox-sync-status"
+)
+
+// returns the maximum age of the first rule matching the item key, or zero if there is none
+func itemMaxAge(key string, config SyncConfig) time.Duration {
+	for _, rule := range config.ItemMaxAge {
+		// brackets of item key parameters are character classes in patterns, keys given verbatim match as well
+		if strings.EqualFold(rule.Key, key) || matchPattern(rule.Key, key) {
+			return rule.MaxAge
+		}
+	}
+
+	return 0
+}
+
+// items which never received a value are stale as well
+func isStaleItem(item zabbixMetric, config SyncConfig) bool {
+	maxAge := itemMaxAge(item.Key, config)
+
+	return maxAge > 0 && (item.LastClock == 0 || time.Since(time.Unix(int64(item.LastClock), 0)) > maxAge)
+}
+
+func isStale(host *zabbixHostData, config SyncConfig) bool {
+	return config.StaleAfter > 0 && !host.LastSeen.IsZero() && time.Since(host.LastSeen) > config.StaleAfter
+}
+
+// returns the status a device or virtual machine should have, current is empty for objects which do not exist yet and
+// managed is whether the current status was set by this tool
+func desiredStatus(host *zabbixHostData, config SyncConfig, current string, managed bool) string {
+	if current == "" {
+		current = "active"
+	}
+
+	switch _, policy := hostStatePolicy(host, config); policy.Action {
+	case "status":
+		return policy.Status
+	case "inventory":
+		return current
+	}
+
+	// hosts leaving the disabled or maintenance state are brought back, unless the status was set by an operator
+	if managed && isHostStateStatus(current, config) {
+		current = "active"
+	}
+
+	if config.StaleAfter == 0 {
+		return current
+	}
+
+	if isStale(host, config) {
+		return "offline"
+	}
+
+	// hosts reporting again are brought back, unless the status was set by an operator
+	if current == "offline" && managed {
+		return "active"
+	}
+
+	return current
+}
+
+// whether the tool might set statuses other than active
+func usesStatusTag(config SyncConfig) bool {
+	return config.StaleAfter > 0 || config.HostState.Disabled.Action == "status" || config.HostState.Maintenance.Action == "status"
+}
+
+// creates the status tag if it does not exist yet
+func ensureStatusTag(nb *netbox.APIClient, ctx context.Context, dryRun bool, config SyncConfig) {
+	if !usesStatusTag(config) {
+		return
+	}
+
+	result, _, err := nb.ExtrasAPI.ExtrasTagsList(ctx).Slug([]string{statusTagSlug}).Execute()
+	handleError("Querying tags", err)
+
+	if result.Count > 0 {
+		return
+	}
+
+	if dryRun {
+		logger.Info("Would create status tag", "object", "extras.tag", "tag", statusTagSlug)
+		return
+	}
+
+	logger.Info("Creating status tag", "object", "extras.tag", "tag", statusTagSlug)
+	created, response, rerr := nb.ExtrasAPI.ExtrasTagsCreate(ctx).TagRequest(*netbox.NewTagRequest(statusTagName, statusTagSlug)).Execute()
+	handleResponse(logger, created, response, rerr)
+}
+
+func hasStatusTag(tags []netbox.NestedTag) bool {
+	for _, tag := range tags {
+		if tag.Slug == statusTagSlug {
+			return true
+		}
+	}
+
+	return false
+}
+
+// returns whether the new status is to be marked as set by this tool
+func isManagedStatus(old string, new string, managed bool) bool {
+	if old == new {
+		return managed
+	}
+
+	return new != "active"
+}
+
+// returns the tags of an object with the status tag added or removed, all tags need to be passed as NetBox replaces the list
+func statusTags(tags []netbox.NestedTag, managed bool) []netbox.NestedTagRequest {
+	requests := []netbox.NestedTagRequest{}
+
+	for _, tag := range tags {
+		if tag.Slug != statusTagSlug {
+			requests = append(requests, *netbox.NewNestedTagRequest(tag.Name, tag.Slug))
+		}
+	}
+
+	if managed {
+		requests = append(requests, *netbox.NewNestedTagRequest(statusTagName, statusTagSlug))
+	}
+
+	return requests
+}
+
+// the status, status tag and last seen changes of an existing device or virtual machine, empty values are unchanged
+type statusUpdate struct {
+	Status       string
+	Tags         []netbox.NestedTagRequest
+	CustomFields map[string]interface{}
+}
+
+// determines and records the status, status tag and last seen changes of an existing device or virtual machine
+func updateStatus(host *zabbixHostData, config SyncConfig, log *slog.Logger, objtype string, objid int32, status_old string, tags []netbox.NestedTag, customFields map[string]interface{}) statusUpdate {
+	var update statusUpdate
+
+	managed_old := hasStatusTag(tags)
+	status_new := desiredStatus(host, config, status_old, managed_old)
+	if status_new != status_old {
+		log.Info("Field changed", "field", "status", "old", status_old, "new", status_new, "last_seen", host.LastSeen)
+		update.Status = status_new
+		recordChange(host, change{Object: objtype, ID: objid, Action: "update", Field: "status", Old: status_old, New: status_new})
+	}
+
+	managed_new := isManagedStatus(status_old, status_new, managed_old)
+	if managed_new != managed_old {
+		log.Debug("Field changed", "field", "tags", "tag", statusTagSlug, "old", managed_old, "new", managed_new)
+		update.Tags = statusTags(tags, managed_new)
+	}
+
+	lastseen_old, lastseen_new := lastSeenUpdate(host, config, customFields)
+	if lastseen_new != "" {
+		log.Debug("Field changed", "field", "custom_fields."+config.LastSeenField, "old", lastseen_old, "new", lastseen_new)
+		update.CustomFields = map[string]interface{}{config.LastSeenField: lastseen_new}
+		recordChange(host, change{Object: objtype, ID: objid, Action: "update", Field: "custom_fields." + config.LastSeenField, Old: lastseen_old, New: lastseen_new, routine: true})
+	}
+
+	return update
+}
+
+// reasons which only stem from stale or missing items, typically because the host stopped reporting
+var itemErrorReasons = []string{"stale_items", "missing_agent_hostname", "missing_manufacturer", "missing_serial"}
+
+func hasOnlyItemErrors(host *zabbixHostData) bool {
+	for _, reason := range host.Reasons {
+		if !contains(itemErrorReasons, reason) {
+			return false
+		}
+	}
+
+	return len(host.Reasons) > 0
+}
+
+// hosts which cannot be synced due to stale or missing items still get the status and last seen time of their existing
+// device or virtual machine updated, otherwise hosts which stopped reporting would never be set to offline
+func processStaleHost(host *zabbixHostData, nb *netbox.APIClient, ctx context.Context, dryRun bool, config SyncConfig) {
+	if config.StaleAfter == 0 && config.LastSeenField == "" {
+		return
+	}
+
+	name := host.HostName
+	host.log.Debug("Updating status of host with item errors", "reasons", host.Reasons)
+
+	devices, _, err := nb.DcimAPI.DcimDevicesList(ctx).Name([]string{name}).Limit(2).Execute()
+	handleError("Query of devices", err)
+
+	if len(devices.Results) == 1 {
+		object := devices.Results[0]
+		log := host.log.With("object", "dcim.device", "id", object.Id)
+
+		request := *netbox.NewPatchedWritableDeviceWithConfigContextRequest()
+
+		update := updateStatus(host, config, log, "dcim.device", object.Id, string(object.Status.GetValue()), object.Tags, object.CustomFields)
+		if update.Status != "" {
+			status, err := netbox.NewDeviceStatusValueFromValue(update.Status)
+			handleError("Validation of new status value", err)
+			request.Status = status
+		}
+		request.Tags = update.Tags
+		request.CustomFields = update.CustomFields
+
+		if request.HasStatus() || request.HasTags() || request.HasCustomFields() {
+			log.Debug("Payload", "payload", request)
+
+			if dryRun {
+				log.Info("Would patch object")
+			} else {
+				created, response, rerr := nb.DcimAPI.DcimDevicesPartialUpdate(ctx, object.Id).PatchedWritableDeviceWithConfigContextRequest(request).Execute()
+				handleResponse(log, created, response, rerr)
+				addJournalEntry(host, nb, ctx, "dcim.device", object.Id, config.Journal)
+			}
+		}
+	}
+
+	vms, _, err := nb.VirtualizationAPI.VirtualizationVirtualMachinesList(ctx).Name([]string{name}).Limit(2).Execute()
+	handleError("Query of virtual machines", err)
+
+	if len(vms.Results) == 1 {
+		object := vms.Results[0]
+		log := host.log.With("object", "virtualization.virtualmachine", "id", object.Id)
+
+		request := *netbox.NewPatchedWritableVirtualMachineWithConfigContextRequest()
+
+		update := updateStatus(host, config, log, "virtualization.virtualmachine", object.Id, string(object.Status.GetValue()), object.Tags, object.CustomFields)
+		if update.Status != "" {
+			status, err := netbox.NewInventoryItemStatusValueFromValue(update.Status)
+			handleError("Validation of new status value", err)
+			request.Status = status
+		}
+		request.Tags = update.Tags
+		request.CustomFields = update.CustomFields
+
+		if request.HasStatus() || request.HasTags() || request.HasCustomFields() {
+			log.Debug("Payload", "payload", request)
+
+			if dryRun {
+				log.Info("Would patch object")
+			} else {
+				created, response, rerr := nb.VirtualizationAPI.VirtualizationVirtualMachinesPartialUpdate(ctx, object.Id).PatchedWritableVirtualMachineWithConfigContextRequest(request).Execute()
+				handleResponse(log, created, response, rerr)
+				addJournalEntry(host, nb, ctx, "virtualization.virtualmachine", object.Id, config.Journal)
+			}
+		}
+	}
+}
+
+// custom fields of a new device or virtual machine
+func newCustomFields(host *zabbixHostData, config SyncConfig) map[string]interface{} {
+	if config.LastSeenField == "" || host.LastSeen.IsZero() {
+		return nil
+	}
+
+	return map[string]interface{}{config.LastSeenField: formatLastSeen(host.LastSeen)}
+}
+
+func formatLastSeen(lastSeen time.Time) string {
+	return lastSeen.UTC().Format(time.RFC3339)
+}
+
+// returns the new value of the last seen custom field, or an empty string if it does not need to be changed
+func lastSeenUpdate(host *zabbixHostData, config SyncConfig, customFields map[string]interface{}) (string, string) {
+	if config.LastSeenField == "" || host.LastSeen.IsZero() {
+		return "", ""
+	}
+
+	old, _ := customFields[config.LastSeenField].(string)
+
+	// NetBox might return the value in a different format
+	parsed, err := time.Parse(time.RFC3339, old)
+	if err == nil && parsed.Equal(host.LastSeen) {
+		return old, ""
+	}
+
+	return old, formatLastSeen(host.LastSeen)
+}
@@ -0,0 +1,45 @@
+/*
+   Zabbix -> NetBox synchronization tool
+   Copyright (C) 2025  SUSE LLC <georg.pfuetzenreuter@suse.com>
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestItemMaxAge(t *testing.T) {
+	setupTestLogger()
+
+	config := SyncConfig{ItemMaxAge: []ItemAgeRule{
+		{Key: "vm.memory.size[total]", MaxAge: time.Hour},
+		{Key: "sys.hw.*_serial", MaxAge: 2 * time.Hour},
+	}}
+
+	for key, expected := range map[string]time.Duration{
+		"vm.memory.size[total]":     time.Hour,
+		"VM.Memory.Size[Total]":     time.Hour,
+		"vm.memory.size[available]": 0,
+		"sys.hw.board_serial":       2 * time.Hour,
+		"agent.hostname":            0,
+	} {
+		if maxAge := itemMaxAge(key, config); maxAge != expected {
+			t.Errorf("Expected maximum age %s for item '%s', got %s", expected, key, maxAge)
+		}
+	}
+}
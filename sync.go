@@ -23,13 +23,49 @@ import (
 	"fmt"
 	"github.com/fabiang/go-zabbix"
 	"github.com/netbox-community/go-netbox/v4"
+	"slices"
 	"strings"
 )
 
-func prepare(z *zabbix.Session, zh *zabbixHosts, whitelistedHostgroups []string, limit string) {
-	workHosts := getHosts(z, filterHostGroupIds(getHostGroups(z), whitelistedHostgroups))
+func prepare(z *zabbix.Session, zh *zabbixHosts, globalConfig *Config, selection hostSelection) {
+	config := globalConfig.Sync
+	// nil if the selection is not restricted to host IDs
+	limitIds := selectorIds(selection.limit)
+	if selection.ids != nil {
+		limitIds = selection.ids
+	}
+
+	hostGroups := getHostGroups(z)
+	includeIds := filterHostGroupIds(hostGroups, globalConfig.HostGroups, globalConfig.HostGroupSubgroups, globalConfig.hostGroupExpressions, "Included")
+	excludeIds := filterHostGroupIds(hostGroups, globalConfig.ExcludeHostGroups, globalConfig.HostGroupSubgroups, globalConfig.hostGroupExpressions, "Excluded")
+
+	if len(globalConfig.HostGroups) > 0 && len(includeIds) == 0 {
+		// an empty list of host group IDs would cause all hosts to be selected
+		Fatal("No host groups match the configured host groups.")
+	}
+
+	excluded := append(getHostsInGroups(z, excludeIds, limitIds), getHostsWithTags(z, globalConfig.ExcludeTags, limitIds)...)
+	workHosts := excludeHosts(getHosts(z, includeIds, limitIds, globalConfig.Tags), excluded)
 	hostIds := filterHostIds(workHosts)
-	filterHostInterfaces(zh, getHostInterfaces(z, hostIds))
+	filterHostInterfaces(zh, getHostInterfaces(z, hostIds), config.ReverseDNS)
+
+	// the selection is applied before querying items, which is the most expensive query
+	limit := newHostMatcher(z, hostGroups, selection.limit, globalConfig.HostGroupSubgroups, limitIds)
+	exclude := newHostMatcher(z, hostGroups, append(slices.Clone(globalConfig.ExcludeHosts), selection.exclude...), globalConfig.HostGroupSubgroups, limitIds)
+	selectHosts(zh, workHosts, limit, exclude)
+	resolveHostNames(zh, config.ReverseDNS)
+	hostIds = applyHostStates(zh, workHosts, config)
+	setHostInventories(zh, workHosts)
+
+	if len(hostIds) == 0 {
+		// an empty host list would cause subsequent queries to not filter by host at all
+		logger.Warn("No hosts match the host selection")
+		return
+	}
+
+	if len(config.Macros.Keys) > 0 {
+		setHostMacros(zh, getHostMacros(z, hostIds))
+	}
 
 	search := make(map[string][]string)
 	search["key_"] = []string{
@@ -48,11 +84,40 @@ func prepare(z *zabbix.Session, zh *zabbixHosts, whitelistedHostgroups []string,
 		"vm.memory.size[total]",
 	}
 
-	filterItems(zh, getItems(z, hostIds, search), search["key_"])
-	scanHosts(zh, limit)
+	if config.VirtualizationItem != "" {
+		search["key_"] = append(search["key_"], config.VirtualizationItem)
+	}
+
+	search["key_"] = append(search["key_"], config.SNMP.Items.keys()...)
+
+	filterItems(zh, getItems(z, hostIds, search), search["key_"], config)
+	scanHosts(zh, config)
 }
 
-func processSite(name string, sites []site) *site {
+// returns the site named by the "site" metadata key, which is only used if enabled to avoid moving hosts unexpectedly
+func metadataSite(host *zabbixHostData, config SyncConfig) string {
+	if !config.SiteFromMetadata {
+		return ""
+	}
+
+	return host.Meta["site"]
+}
+
+// if enabled, the site named by the "site" metadata key takes precedence over the one derived from the domain of the host name
+func processSite(host *zabbixHostData, sites []site, config SyncConfig) *site {
+	if value := metadataSite(host, config); value != "" {
+		for _, s := range sites {
+			if strings.EqualFold(s.Slug, value) || strings.EqualFold(s.Name, value) {
+				return &s
+			}
+		}
+
+		host.log.Warn("Host serves unknown site in metadata", "site", value)
+
+		return nil
+	}
+
+	name := host.HostName
 	name_parts := strings.Split(name, ".")
 	domain_parts := name_parts[len(name_parts)-3:]
 	if strings.Contains(domain_parts[0], "-") {
@@ -61,7 +126,7 @@ func processSite(name string, sites []site) *site {
 	domain := strings.Join(domain_parts, ".")
 
 	for _, s := range sites {
-		if s.Domain == domain {
+		if s.Domain != "" && s.Domain == domain {
 			return &s
 		}
 	}
@@ -69,17 +134,19 @@ func processSite(name string, sites []site) *site {
 	return nil
 }
 
-func processMacAddress(nb *netbox.APIClient, ctx context.Context, address string, dryRun bool) (int32, bool) {
+func processMacAddress(host *zabbixHostData, nb *netbox.APIClient, ctx context.Context, address string, dryRun bool) (int32, bool) {
 	// some interface types have an empty MAC address, for example WireGuard ones - behave as if the MAC address already exists
 	if address == "" {
 		return 0, true
 	}
 
-	Debug("Processing MAC address %s", address)
+	log := host.log.With("object", "dcim.macaddress", "address", address)
+
+	log.Debug("Processing MAC address")
 	query, _, err := nb.DcimAPI.DcimMacAddressesList(ctx).MacAddress([]string{address}).Execute()
 	handleError("Query of MAC addresses", err)
 	found := query.Results
-	Debug("Found MAC addresses: %+v", found)
+	log.Debug("Found MAC addresses", "objects", found)
 
 	var objid int32
 	var assigned bool
@@ -87,38 +154,40 @@ func processMacAddress(nb *netbox.APIClient, ctx context.Context, address string
 	switch len(found) {
 	case 0:
 		if dryRun {
-			Info("Would create MAC address object '%s'", address)
+			log.Info("Would create MAC address object")
+			recordChange(host, change{Object: "dcim.macaddress", Action: "create", New: address})
 			return objid, assigned
 		}
 
-		Info("Creating MAC address object '%s'", address)
+		log.Info("Creating MAC address object")
 
 		created, response, rerr := nb.DcimAPI.DcimMacAddressesCreate(ctx).MACAddressRequest(*netbox.NewMACAddressRequest(address)).Execute()
-		handleResponse(created, response, rerr)
+		handleResponse(log, created, response, rerr)
 
 		objid = created.Id
 		assigned = false
+		recordChange(host, change{Object: "dcim.macaddress", ID: objid, Action: "create", New: address})
 
 	case 1:
-		Debug("MAC address object '%s' already exists", address)
-
 		objid = found[0].Id
 
+		log.Debug("MAC address object already exists", "id", objid)
+
 		if found[0].AssignedObjectType.IsSet() && found[0].AssignedObjectId.IsSet() {
 			assigned = true
 		}
 
 	default:
-		Warn("MAC address object '%s' exists multiple times", address)
+		log.Warn("MAC address object exists multiple times", "count", len(found))
 	}
 
 	return objid, assigned
 }
 
-func processIpAddress(hinf *ipRoute2Interface, nbobjtype string, nbinfid int64, nb *netbox.APIClient, ctx context.Context, dnsname string, dryRun bool) {
+func processIpAddress(host *zabbixHostData, hinf *ipRoute2Interface, nbobjtype string, nbinfid int64, nb *netbox.APIClient, ctx context.Context, dnsname string, dryRun bool) {
 	for _, address := range hinf.AddrInfo {
 		if isLinkLocal(address.Local) {
-			Debug("Skipping link local IP adress %s", address.Local)
+			host.log.Debug("Skipping link local IP address", "address", address.Local)
 			// currently we do not track these in NetBox
 			// it might make sense to later add logic to differentiate SLAAC and Privacy addresses
 			continue
@@ -126,13 +195,15 @@ func processIpAddress(hinf *ipRoute2Interface, nbobjtype string, nbinfid int64,
 
 		cidraddress := fmt.Sprintf("%s/%d", address.Local, address.Prefixlen)
 
-		Debug("Processing IP address %s", cidraddress)
+		log := host.log.With("object", "ipam.ipaddress", "address", cidraddress)
+
+		log.Debug("Processing IP address")
 
 		ipquery, response, err := nb.IpamAPI.IpamIpAddressesList(ctx).Address([]string{cidraddress}).Execute()
-		handleResponse(ipquery, response, err)
+		handleResponse(log, ipquery, response, err)
 		handleError("Query of IP addresses", err)
 		ipfound := ipquery.Results
-		Debug("Found IP addresses: %+v", ipfound)
+		log.Debug("Found IP addresses", "objects", ipfound)
 		foundcount := len(ipfound)
 
 		var found bool
@@ -174,15 +245,16 @@ func processIpAddress(hinf *ipRoute2Interface, nbobjtype string, nbinfid int64,
 			request := *netbox.NewPatchedWritableIPAddressRequest()
 
 			if dnsname != "" && dnsname != *nbipo.DnsName {
-				Info("DNS Name changed: %s => %s", *nbipo.DnsName, dnsname)
+				log.Info("Field changed", "id", ipobjid, "field", "dns_name", "old", *nbipo.DnsName, "new", dnsname)
 				request.SetDnsName(dnsname)
+				recordChange(host, change{Object: "ipam.ipaddress", ID: ipobjid, Action: "update", Field: "dns_name", Old: *nbipo.DnsName, New: dnsname})
 			}
 
 			if request.HasDnsName() {
-				Debug("Payload: %+v", request)
+				log.Debug("Payload", "id", ipobjid, "payload", request)
 
 				if dryRun {
-					Info("Would patch object")
+					log.Info("Would patch object", "id", ipobjid)
 					continue
 				}
 
@@ -191,23 +263,26 @@ func processIpAddress(hinf *ipRoute2Interface, nbobjtype string, nbinfid int64,
 		}
 
 		if foundcount == 1 && unassignedcount == 1 {
+			recordChange(host, change{Object: "ipam.ipaddress", ID: ipobjid, Action: "assign", Field: "assigned_object", New: fmt.Sprintf("%s:%d", nbobjtype, nbinfid)})
+
 			if dryRun {
-				Info("Would assign existing IP address object")
+				log.Info("Would assign existing IP address object", "id", ipobjid, "assigned_object_type", nbobjtype, "assigned_object_id", nbinfid)
 				continue
 			}
 
-			assignIpAddress(nb, ctx, ipobjid, cidraddress, nbobjtype, nbinfid)
+			assignIpAddress(host.log, nb, ctx, ipobjid, cidraddress, nbobjtype, nbinfid)
 
 		} else if foundcount > 1 && unassignedcount > 1 {
-			Error("Multiple unassigned IP addresses match %s, cannot decide", cidraddress)
+			log.Error("Multiple unassigned IP addresses match, cannot decide", "count", unassignedcount)
 
 		} else if foundcount == 0 && unassignedcount == 0 {
 			if dryRun {
-				Info("Would create IP address object")
+				log.Info("Would create IP address object")
+				recordChange(host, change{Object: "ipam.ipaddress", Action: "create", New: cidraddress})
 				continue
 			}
 
-			Info("Creating IP address object '%s'", cidraddress)
+			log.Info("Creating IP address object")
 
 			status, err := netbox.NewPatchedWritableIPAddressRequestStatusFromValue("active")
 			if err != nil {
@@ -226,24 +301,181 @@ func processIpAddress(hinf *ipRoute2Interface, nbobjtype string, nbinfid int64,
 			}
 
 			created, response, rerr := nb.IpamAPI.IpamIpAddressesCreate(ctx).WritableIPAddressRequest(request).Execute()
-			handleResponse(created, response, rerr)
+			handleResponse(log, created, response, rerr)
+			recordChange(host, change{Object: "ipam.ipaddress", ID: created.Id, Action: "create", New: cidraddress})
 
 		} else if !found {
-			Debug("found %v, foundcount %d, unassignedcount %d", found, foundcount, unassignedcount)
+			log.Debug("Unhandled IP address situation", "found", found, "foundcount", foundcount, "unassignedcount", unassignedcount)
 			Fatal("processIpAddress() unhandled situation, this should never happen")
 		}
 	}
 }
 
+func siteSource(host *zabbixHostData, config SyncConfig) string {
+	if metadataSite(host, config) != "" {
+		return "metadata"
+	}
+
+	return "domain"
+}
+
+// assigns the IPMI address to the management interface of the device and sets it as the out-of-band IP address
+func processOobAddress(host *zabbixHostData, nb *netbox.APIClient, ctx context.Context, devobjid int32, oobip netbox.NullableBriefIPAddress, dryRun bool, config SyncConfig) {
+	log := host.log.With("object", "dcim.interface", "interface", config.OobInterface)
+
+	var intobjid int32
+
+	if devobjid > 0 {
+		ifquery, response, err := nb.DcimAPI.DcimInterfacesList(ctx).DeviceId([]int32{devobjid}).Name([]string{config.OobInterface}).Execute()
+		handleResponse(log, ifquery, response, err)
+		log.Debug("Found device interfaces", "objects", ifquery.Results)
+
+		if len(ifquery.Results) > 0 {
+			intobjid = ifquery.Results[0].Id
+		}
+	}
+
+	if intobjid == 0 {
+		if dryRun {
+			log.Info("Would create interface object")
+			recordChange(host, change{Object: "dcim.interface", Action: "create", New: config.OobInterface})
+		} else {
+			iftype, err := netbox.NewInterfaceTypeValueFromValue("other")
+			handleError("Constructing interface type from string", err)
+
+			// device names are not unique across sites, hence the device is referenced by its ID
+			device := netbox.BriefDeviceRequest{AdditionalProperties: map[string]interface{}{"id": devobjid}}
+			request := *netbox.NewWritableInterfaceRequest(device, config.OobInterface, *iftype)
+			request.SetMgmtOnly(true)
+
+			log.Info("Creating interface object")
+			log.Debug("Payload", "payload", request)
+
+			created, response, rerr := nb.DcimAPI.DcimInterfacesCreate(ctx).WritableInterfaceRequest(request).Execute()
+			handleResponse(log, created, response, rerr)
+			intobjid = created.Id
+			recordChange(host, change{Object: "dcim.interface", ID: intobjid, Action: "create", New: config.OobInterface})
+		}
+	}
+
+	log = host.log.With("object", "ipam.ipaddress", "address", host.OobAddress)
+
+	// without a prefix length, addresses with any prefix length match
+	ipquery, response, err := nb.IpamAPI.IpamIpAddressesList(ctx).Address([]string{host.OobAddress}).Execute()
+	handleResponse(log, ipquery, response, err)
+	log.Debug("Found IP addresses", "objects", ipquery.Results)
+
+	var ipobjid int32
+	var cidraddress string
+	var unassigned []netbox.IPAddress
+
+	for _, nbip := range ipquery.Results {
+		if intobjid > 0 && nbip.GetAssignedObjectType() == "dcim.interface" && nbip.GetAssignedObjectId() == int64(intobjid) {
+			ipobjid = nbip.Id
+			cidraddress = nbip.Address
+			break
+		}
+
+		if nbip.GetAssignedObjectId() == 0 {
+			unassigned = append(unassigned, nbip)
+		}
+	}
+
+	if ipobjid == 0 {
+		switch {
+		case len(unassigned) == 1:
+			ipobjid = unassigned[0].Id
+			cidraddress = unassigned[0].Address
+			recordChange(host, change{Object: "ipam.ipaddress", ID: ipobjid, Action: "assign", Field: "assigned_object", New: fmt.Sprintf("dcim.interface:%d", intobjid)})
+
+			if dryRun {
+				log.Info("Would assign existing IP address object", "id", ipobjid, "assigned_object_type", "dcim.interface", "assigned_object_id", intobjid)
+			} else {
+				assignIpAddress(host.log, nb, ctx, ipobjid, cidraddress, "dcim.interface", int64(intobjid))
+			}
+
+		case len(unassigned) > 1:
+			log.Error("Multiple unassigned IP addresses match, cannot decide", "count", len(unassigned))
+			return
+
+		case len(ipquery.Results) > 0:
+			log.Error("IP address is assigned to a different object", "objects", ipquery.Results)
+			return
+
+		default:
+			cidraddress = host.OobAddress + "/32"
+			if strings.Contains(host.OobAddress, ":") {
+				cidraddress = host.OobAddress + "/128"
+			}
+
+			if dryRun {
+				log.Info("Would create IP address object")
+				recordChange(host, change{Object: "ipam.ipaddress", Action: "create", New: cidraddress})
+			} else {
+				log.Info("Creating IP address object")
+
+				status, err := netbox.NewPatchedWritableIPAddressRequestStatusFromValue("active")
+				handleError("Validation of new status value", err)
+
+				objtype := "dcim.interface"
+				infid := int64(intobjid)
+				request := netbox.WritableIPAddressRequest{
+					Address:            cidraddress,
+					Status:             status,
+					AssignedObjectType: *netbox.NewNullableString(&objtype),
+					AssignedObjectId:   *netbox.NewNullableInt64(&infid),
+				}
+
+				created, response, rerr := nb.IpamAPI.IpamIpAddressesCreate(ctx).WritableIPAddressRequest(request).Execute()
+				handleResponse(log, created, response, rerr)
+				ipobjid = created.Id
+				recordChange(host, change{Object: "ipam.ipaddress", ID: ipobjid, Action: "create", New: cidraddress})
+			}
+		}
+	}
+
+	log = host.log.With("object", "dcim.device")
+	if devobjid > 0 {
+		log = log.With("id", devobjid)
+	}
+
+	oob_old := ""
+	if oobip.IsSet() && oobip.Get() != nil {
+		oob_old = oobip.Get().Address
+		if ipobjid > 0 && oobip.Get().Id == ipobjid {
+			return
+		}
+	}
+
+	log.Info("Field changed", "field", "oob_ip", "old", oob_old, "new", cidraddress, "source", "ipmi")
+	recordChange(host, change{Object: "dcim.device", ID: devobjid, Action: "update", Field: "oob_ip", Old: oob_old, New: cidraddress})
+
+	if dryRun {
+		log.Info("Would patch object")
+		return
+	}
+
+	request := *netbox.NewPatchedWritableDeviceWithConfigContextRequest()
+	// the address alone can match multiple objects, for example in different VRFs
+	oobipreq := *netbox.NewBriefIPAddressRequest(cidraddress)
+	oobipreq.AdditionalProperties = map[string]interface{}{"id": ipobjid}
+	request.SetOobIp(oobipreq)
+
+	log.Debug("Payload", "payload", request)
+
+	created, response, rerr := nb.DcimAPI.DcimDevicesPartialUpdate(ctx, devobjid).PatchedWritableDeviceWithConfigContextRequest(request).Execute()
+	handleResponse(log, created, response, rerr)
+}
+
 func processVirtualMachineInterface(host *zabbixHostData, nb *netbox.APIClient, ctx context.Context, vmname string, vmobjid int32, dryRun bool) {
 	var iffound []netbox.VMInterface
 
 	if vmobjid > 0 {
 		ifquery, response, err := nb.VirtualizationAPI.VirtualizationInterfacesList(ctx).VirtualMachineId([]int32{vmobjid}).Execute()
-		handleResponse(ifquery, response, err)
+		handleResponse(host.log, ifquery, response, err)
 		handleError("Query of virtual machine interfaces", err)
 		iffound = ifquery.Results
-		Debug("Found virtual machine interfaces: %+v", iffound)
+		host.log.Debug("Found virtual machine interfaces", "objects", iffound)
 	}
 
 	hinfcount := len(host.Interfaces)
@@ -254,27 +486,26 @@ func processVirtualMachineInterface(host *zabbixHostData, nb *netbox.APIClient,
 		}
 	}
 
-	var dnsname string
-
-	if hinfcount == 1 {
-		dnsname = vmname
-	} else {
-		// no logic to determine primary interface amongst multiple yet
-		dnsname = ""
-	}
-
 	for _, inf := range host.Interfaces {
 		if inf.IfName == "lo" {
 			continue
 		}
 
+		// amongst multiple interfaces, the one carrying the address Zabbix connects to is the primary one
+		var dnsname string
+		if hinfcount == 1 || inf.hasAddress(host.PrimaryAddress) {
+			dnsname = vmname
+		}
+
 		mtu := *netbox.NewNullableInt32(&inf.Mtu)
 
 		var found bool
 		var intobjid int32
 		var nbinf netbox.VMInterface
 
-		Debug("Scanning %+v", inf)
+		log := host.log.With("object", "virtualization.vminterface", "interface", inf.IfName)
+
+		log.Debug("Scanning interface", "data", inf)
 		for _, nbif := range iffound {
 			if inf.IfName == nbif.Name {
 				// UPDATE
@@ -286,7 +517,7 @@ func processVirtualMachineInterface(host *zabbixHostData, nb *netbox.APIClient,
 			}
 		}
 
-		macobjid, macassigned := processMacAddress(nb, ctx, inf.Address, dryRun)
+		macobjid, macassigned := processMacAddress(host, nb, ctx, inf.Address, dryRun)
 		nbmac := *netbox.NewNullableBriefMACAddressRequest(netbox.NewBriefMACAddressRequest(strings.ToUpper(inf.Address)))
 
 		if found {
@@ -295,34 +526,37 @@ func processVirtualMachineInterface(host *zabbixHostData, nb *netbox.APIClient,
 			mac_new := nbmac.Get().GetMacAddress()
 			mac_old := nbinf.PrimaryMacAddress.Get().GetMacAddress()
 			if mac_new != mac_old {
-				Info("Primary MAC address changed: %s => %s", mac_old, mac_new)
+				log.Info("Field changed", "id", intobjid, "field", "primary_mac_address", "old", mac_old, "new", mac_new)
 				request.PrimaryMacAddress = nbmac
+				recordChange(host, change{Object: "virtualization.vminterface", ID: intobjid, Action: "update", Field: "primary_mac_address", Old: mac_old, New: mac_new})
 			}
 
 			mtu_new := *mtu.Get()
 			mtu_old := *nbinf.Mtu.Get()
 			if mtu_new != mtu_old {
-				Info("MTU changed: %d => %d", mtu_old, mtu_new)
+				log.Info("Field changed", "id", intobjid, "field", "mtu", "old", mtu_old, "new", mtu_new)
 				request.Mtu = mtu
+				recordChange(host, change{Object: "virtualization.vminterface", ID: intobjid, Action: "update", Field: "mtu", Old: mtu_old, New: mtu_new})
 			}
 
 			// TODO: compare/update tagged VLANs
 
 			if request.HasPrimaryMacAddress() || request.HasMtu() {
-				Debug("Payload: %+v", request)
+				log.Debug("Payload", "id", intobjid, "payload", request)
 
 				if dryRun {
-					Info("Would patch object")
+					log.Info("Would patch object", "id", intobjid)
 					continue
 				}
 
 				created, response, rerr := nb.VirtualizationAPI.VirtualizationInterfacesPartialUpdate(ctx, intobjid).PatchedWritableVMInterfaceRequest(request).Execute()
-				handleResponse(created, response, rerr)
+				handleResponse(log, created, response, rerr)
 			}
 
 		} else {
 			if dryRun {
-				Info("Would create interface object")
+				log.Info("Would create interface object")
+				recordChange(host, change{Object: "virtualization.vminterface", Action: "create", New: inf.IfName})
 			} else {
 				request := netbox.WritableVMInterfaceRequest{
 					VirtualMachine: *netbox.NewBriefVirtualMachineRequest(vmname),
@@ -340,16 +574,20 @@ func processVirtualMachineInterface(host *zabbixHostData, nb *netbox.APIClient,
 					request.TaggedVlans = append(request.TaggedVlans, inf.LinkInfo.Data.(iproute2LinkInfoDataVlan).Id)
 				}
 
+				log.Info("Creating interface object")
+
 				created, response, rerr := nb.VirtualizationAPI.VirtualizationInterfacesCreate(ctx).WritableVMInterfaceRequest(request).Execute()
-				handleResponse(created, response, rerr)
+				handleResponse(log, created, response, rerr)
 
 				intobjid = created.Id
+				recordChange(host, change{Object: "virtualization.vminterface", ID: intobjid, Action: "create", New: inf.IfName})
 
 			}
 		}
 
 		if macobjid > 0 && !macassigned && !dryRun {
-			assignMacAddress(nb, ctx, macobjid, inf.Address, "virtualization.vminterface", int64(intobjid))
+			assignMacAddress(host.log, nb, ctx, macobjid, inf.Address, "virtualization.vminterface", int64(intobjid))
+			recordChange(host, change{Object: "dcim.macaddress", ID: macobjid, Action: "assign", Field: "assigned_object", New: fmt.Sprintf("virtualization.vminterface:%d", intobjid)})
 		}
 
 		// cannot set PrimaryMacAddress during creation as assignment needs to happen first
@@ -359,67 +597,101 @@ func processVirtualMachineInterface(host *zabbixHostData, nb *netbox.APIClient,
 			}
 
 			created, response, rerr := nb.VirtualizationAPI.VirtualizationInterfacesPartialUpdate(ctx, intobjid).PatchedWritableVMInterfaceRequest(request).Execute()
-			handleResponse(created, response, rerr)
+			handleResponse(log, created, response, rerr)
 		}
 
-		processIpAddress(inf, "virtualization.vminterface", int64(intobjid), nb, ctx, dnsname, dryRun)
+		processIpAddress(host, inf, "virtualization.vminterface", int64(intobjid), nb, ctx, dnsname, dryRun)
 
 	}
 }
 
-func processDevice(host *zabbixHostData, nb *netbox.APIClient, ctx context.Context, dryRun bool, config SyncConfig, sitemeta site) {
+func processDevice(host *zabbixHostData, nb *netbox.APIClient, ctx context.Context, dryRun bool, config SyncConfig, sitemeta site, objects inventoryObjects) {
 	name := host.HostName
 	query, _, err := nb.DcimAPI.DcimDevicesList(ctx).Name([]string{name}).Limit(2).Execute()
 	handleError("Query of devices", err)
 	found := query.Results
-	Debug("Found devices: %+v", found)
+	log := host.log.With("object", "dcim.device")
+	log.Debug("Found devices", "objects", found)
 	foundcount := len(found)
 
 	devicemanufacturer := *netbox.NewBriefManufacturerRequest(host.Manufacturer, "")
 	devicetype := *netbox.NewBriefDeviceTypeRequest(devicemanufacturer, host.Model, "")
-	devicerole := *netbox.NewBriefDeviceRoleRequest("Server", "")
+	role := "Server"
+	if host.Interface == "snmp" {
+		role = config.SNMP.Role
+	}
+
+	devicerole := *netbox.NewBriefDeviceRoleRequest(role, "")
 	deviceserial := host.Serial
 	devicesite := *netbox.NewBriefSiteRequest(sitemeta.Name, sitemeta.Slug)
 
 	var devobjid int32
+	var oobip netbox.NullableBriefIPAddress
+
+	platform := inventoryPlatform(host, objects, config)
+	location := inventoryLocation(host, objects, config, sitemeta.Slug)
+	assettag := host.AssetTag
 
 	switch foundcount {
 	case 0:
 		if dryRun {
-			Info("Would create device object")
+			log.Info("Would create device object")
+			recordChange(host, change{Object: "dcim.device", Action: "create", New: name})
 		} else {
-			Info("Creating device object")
+			log.Info("Creating device object")
 
-			status, err := netbox.NewDeviceStatusValueFromValue("active")
+			status_new := desiredStatus(host, config, "", false)
+			status, err := netbox.NewDeviceStatusValueFromValue(status_new)
 			if err != nil {
 				handleError("Validation of new status value", err)
 			}
 
 			request := netbox.WritableDeviceWithConfigContextRequest{
-				Name:       *netbox.NewNullableString(&name),
-				DeviceType: devicetype,
-				Role:       devicerole,
-				Serial:     &deviceserial,
-				Site:       devicesite,
-				Status:     status,
+				Name:         *netbox.NewNullableString(&name),
+				DeviceType:   devicetype,
+				Role:         devicerole,
+				Serial:       &deviceserial,
+				Site:         devicesite,
+				Status:       status,
+				CustomFields: newCustomFields(host, config),
 			}
 
-			Debug("Payload: %+v", request)
+			if status_new != "active" {
+				request.Tags = statusTags(nil, true)
+			}
+
+			if platform != nil {
+				request.Platform = *netbox.NewNullableBriefPlatformRequest(netbox.NewBriefPlatformRequest(platform.Name, platform.Slug))
+			}
+
+			if location != nil {
+				request.Location = *netbox.NewNullableBriefLocationRequest(netbox.NewBriefLocationRequest(location.Name, location.Slug))
+			}
+
+			if config.Inventory.Enabled && assettag != "" {
+				request.AssetTag = *netbox.NewNullableString(&assettag)
+			}
+
+			log.Debug("Payload", "payload", request)
 			created, response, rerr := nb.DcimAPI.DcimDevicesCreate(ctx).WritableDeviceWithConfigContextRequest(request).Execute()
-			handleResponse(created, response, rerr)
+			handleResponse(log, created, response, rerr)
 			devobjid = created.Id
+			recordChange(host, change{Object: "dcim.device", ID: devobjid, Action: "create", New: name})
 		}
 
 	case 1:
 		object := found[0]
+		log = log.With("id", object.Id)
+		oobip = object.OobIp
 
 		request := *netbox.NewPatchedWritableDeviceWithConfigContextRequest()
 
 		site_new := devicesite
 		site_old := object.Site
 		if site_new.GetSlug() != site_old.GetSlug() {
-			Info("Site changed by domain: %s (%s) => %s (%s)", site_old.Name, site_old.Slug, site_new.Name, site_new.Slug)
+			log.Info("Field changed", "field", "site", "old", site_old.Slug, "new", site_new.Slug, "source", siteSource(host, config))
 			request.Site = &devicesite
+			recordChange(host, change{Object: "dcim.device", ID: object.Id, Action: "update", Field: "site", Old: site_old.Slug, New: site_new.Slug})
 		}
 
 		unidentifiable_manufacturer := false
@@ -432,98 +704,160 @@ func processDevice(host *zabbixHostData, nb *netbox.APIClient, ctx context.Conte
 			unidentifiable_manufacturer = true
 		}
 		if !unidentifiable_manufacturer && (devicemanufacturer_new != devicemanufacturer_old || devicetype_new != devicetype_old) {
-			Info("Device type changed: %s %s => %s %s", devicemanufacturer_old, devicetype_old, devicemanufacturer_new, devicetype_new)
+			log.Info("Field changed", "field", "device_type", "old", devicemanufacturer_old+" "+devicetype_old, "new", devicemanufacturer_new+" "+devicetype_new)
 			request.DeviceType = &devicetype
+			recordChange(host, change{Object: "dcim.device", ID: object.Id, Action: "update", Field: "device_type", Old: devicemanufacturer_old + " " + devicetype_old, New: devicemanufacturer_new + " " + devicetype_new})
 		}
 
 		devicerole_new := devicerole.GetName()
 		devicerole_old := object.Role.GetName()
 		if devicerole_new != devicerole_old {
-			Info("Device role changed: %s => %s", devicerole_old, devicerole_new)
+			log.Info("Field changed", "field", "role", "old", devicerole_old, "new", devicerole_new)
 			request.Role = &devicerole
+			recordChange(host, change{Object: "dcim.device", ID: object.Id, Action: "update", Field: "role", Old: devicerole_old, New: devicerole_new})
 		}
 
 		deviceserial_old := *object.Serial
 		if !unidentifiable_manufacturer && deviceserial != deviceserial_old {
-			Info("Device serial changed: %s => %s", deviceserial_old, deviceserial)
+			log.Info("Field changed", "field", "serial", "old", deviceserial_old, "new", deviceserial)
 			request.Serial = &deviceserial
+			recordChange(host, change{Object: "dcim.device", ID: object.Id, Action: "update", Field: "serial", Old: deviceserial_old, New: deviceserial})
 		}
 
-		if request.HasSite() || request.HasDeviceType() || request.HasRole() || request.HasSerial() {
-			Debug("Payload: %+v", request)
+		if platform != nil && platform.Id != object.Platform.Get().GetId() {
+			platform_old := object.Platform.Get().GetName()
+			log.Info("Field changed", "field", "platform", "old", platform_old, "new", platform.Name)
+			request.Platform = *netbox.NewNullableBriefPlatformRequest(netbox.NewBriefPlatformRequest(platform.Name, platform.Slug))
+			recordChange(host, change{Object: "dcim.device", ID: object.Id, Action: "update", Field: "platform", Old: platform_old, New: platform.Name})
+		}
+
+		if location != nil && location.Id != object.Location.Get().GetId() {
+			location_old := object.Location.Get().GetName()
+			log.Info("Field changed", "field", "location", "old", location_old, "new", location.Name)
+			request.Location = *netbox.NewNullableBriefLocationRequest(netbox.NewBriefLocationRequest(location.Name, location.Slug))
+			recordChange(host, change{Object: "dcim.device", ID: object.Id, Action: "update", Field: "location", Old: location_old, New: location.Name})
+		}
+
+		var assettag_old string
+		if object.AssetTag.Get() != nil {
+			assettag_old = *object.AssetTag.Get()
+		}
+		if config.Inventory.Enabled && assettag != "" && assettag != assettag_old {
+			log.Info("Field changed", "field", "asset_tag", "old", assettag_old, "new", assettag)
+			request.AssetTag = *netbox.NewNullableString(&assettag)
+			recordChange(host, change{Object: "dcim.device", ID: object.Id, Action: "update", Field: "asset_tag", Old: assettag_old, New: assettag})
+		}
+
+		update := updateStatus(host, config, log, "dcim.device", object.Id, string(object.Status.GetValue()), object.Tags, object.CustomFields)
+		if update.Status != "" {
+			status, err := netbox.NewDeviceStatusValueFromValue(update.Status)
+			handleError("Validation of new status value", err)
+			request.Status = status
+		}
+		request.Tags = update.Tags
+		request.CustomFields = update.CustomFields
+
+		if request.HasSite() || request.HasDeviceType() || request.HasRole() || request.HasSerial() || request.HasStatus() || request.HasCustomFields() || request.HasPlatform() || request.HasLocation() || request.HasAssetTag() || request.HasTags() {
+			log.Debug("Payload", "payload", request)
 
 			if dryRun {
-				Info("Would patch object")
-				return
+				log.Info("Would patch object")
+				devobjid = object.Id
+			} else {
+				created, response, rerr := nb.DcimAPI.DcimDevicesPartialUpdate(ctx, object.Id).PatchedWritableDeviceWithConfigContextRequest(request).Execute()
+				handleResponse(log, created, response, rerr)
+				devobjid = created.Id
 			}
 
-			created, response, rerr := nb.DcimAPI.DcimDevicesPartialUpdate(ctx, object.Id).PatchedWritableDeviceWithConfigContextRequest(request).Execute()
-			handleResponse(created, response, rerr)
-			devobjid = created.Id
-
 		} else {
 			devobjid = object.Id
 		}
 
 	default:
-		Error("Host %s matches multiple (%d) objects in NetBox.", name, foundcount)
+		log.Error("Host matches multiple objects in NetBox", "count", foundcount)
+		return
+	}
+
+	log.Debug("Processed device", "id", devobjid)
+
+	if host.OobAddress != "" {
+		processOobAddress(host, nb, ctx, devobjid, oobip, dryRun, config)
 	}
 
-	Debug("%d", devobjid)
+	if !dryRun && devobjid > 0 {
+		addJournalEntry(host, nb, ctx, "dcim.device", devobjid, config.Journal)
+	}
 
 }
 
-func processVirtualMachine(host *zabbixHostData, nb *netbox.APIClient, ctx context.Context, dryRun bool, sitemeta site) {
+func processVirtualMachine(host *zabbixHostData, nb *netbox.APIClient, ctx context.Context, dryRun bool, config SyncConfig, sitemeta site, objects inventoryObjects) {
 	name := host.HostName
 
 	query, _, err := nb.VirtualizationAPI.VirtualizationVirtualMachinesList(ctx).Name([]string{name}).Limit(2).Execute()
 	handleError("Query of virtual machines", err)
 	found := query.Results
-	Debug("Found virtual machines: %+v", found)
+	log := host.log.With("object", "virtualization.virtualmachine")
+	log.Debug("Found virtual machines", "objects", found)
 	foundcount := len(found)
 
 	memory := *netbox.NewNullableInt32(&host.Memory)
 	vcpus := *netbox.NewNullableFloat64(&host.CPUs)
 	nbsite := *netbox.NewNullableBriefSiteRequest(netbox.NewBriefSiteRequest(sitemeta.Name, sitemeta.Slug))
+	platform := inventoryPlatform(host, objects, config)
 
 	var vmobjid int32
 
 	switch foundcount {
 	case 0:
 		if dryRun {
-			Info("Would create virtual machine object")
+			log.Info("Would create virtual machine object")
+			recordChange(host, change{Object: "virtualization.virtualmachine", Action: "create", New: name})
 		} else {
-			Info("Creating virtual machine object")
+			log.Info("Creating virtual machine object")
 
-			status, err := netbox.NewInventoryItemStatusValueFromValue("active")
+			status_new := desiredStatus(host, config, "", false)
+			status, err := netbox.NewInventoryItemStatusValueFromValue(status_new)
 			if err != nil {
 				handleError("Validation of new status value", err)
 			}
 
 			request := netbox.WritableVirtualMachineWithConfigContextRequest{
-				Name:    name,
-				Site:    nbsite,
-				Cluster: *netbox.NewNullableBriefClusterRequest(netbox.NewBriefClusterRequest("Unmapped")),
-				Status:  status,
-				Memory:  memory,
-				Vcpus:   vcpus,
+				Name:         name,
+				Site:         nbsite,
+				Cluster:      *netbox.NewNullableBriefClusterRequest(netbox.NewBriefClusterRequest("Unmapped")),
+				Status:       status,
+				Memory:       memory,
+				Vcpus:        vcpus,
+				CustomFields: newCustomFields(host, config),
+			}
+
+			if status_new != "active" {
+				request.Tags = statusTags(nil, true)
 			}
-			Debug("Payload: %+v", request)
+
+			if platform != nil {
+				request.Platform = *netbox.NewNullableBriefPlatformRequest(netbox.NewBriefPlatformRequest(platform.Name, platform.Slug))
+			}
+
+			log.Debug("Payload", "payload", request)
 			created, response, rerr := nb.VirtualizationAPI.VirtualizationVirtualMachinesCreate(ctx).WritableVirtualMachineWithConfigContextRequest(request).Execute()
-			handleResponse(created, response, rerr)
+			handleResponse(log, created, response, rerr)
 			vmobjid = created.Id
+			recordChange(host, change{Object: "virtualization.virtualmachine", ID: vmobjid, Action: "create", New: name})
 		}
 
 	case 1:
 		object := found[0]
+		log = log.With("id", object.Id)
 
 		request := *netbox.NewPatchedWritableVirtualMachineWithConfigContextRequest()
 
 		site_new := *nbsite.Get()
 		site_old := *object.Site.Get()
 		if site_new.Slug != site_old.Slug {
-			Info("Site changed by domain: %s (%s) => %s (%s)", site_old.Name, site_old.Slug, site_new.Name, site_new.Slug)
+			log.Info("Field changed", "field", "site", "old", site_old.Slug, "new", site_new.Slug, "source", siteSource(host, config))
 			request.Site = nbsite
+			recordChange(host, change{Object: "virtualization.virtualmachine", ID: object.Id, Action: "update", Field: "site", Old: site_old.Slug, New: site_new.Slug})
 		}
 
 		memory_new := *memory.Get()
@@ -532,8 +866,9 @@ func processVirtualMachine(host *zabbixHostData, nb *netbox.APIClient, ctx conte
 			memory_old = *object.Memory.Get()
 		}
 		if memory_new != memory_old {
-			Info("Memory changed: %d => %d", memory_old, memory_new)
+			log.Info("Field changed", "field", "memory", "old", memory_old, "new", memory_new)
 			request.Memory = memory
+			recordChange(host, change{Object: "virtualization.virtualmachine", ID: object.Id, Action: "update", Field: "memory", Old: memory_old, New: memory_new})
 		}
 
 		vcpus_new := *vcpus.Get()
@@ -542,65 +877,101 @@ func processVirtualMachine(host *zabbixHostData, nb *netbox.APIClient, ctx conte
 			vcpus_old = *object.Vcpus.Get()
 		}
 		if vcpus_new != vcpus_old {
-			Info("vCPUs changed: %f => %f", vcpus_old, vcpus_new)
+			log.Info("Field changed", "field", "vcpus", "old", vcpus_old, "new", vcpus_new)
 			request.Vcpus = vcpus
+			recordChange(host, change{Object: "virtualization.virtualmachine", ID: object.Id, Action: "update", Field: "vcpus", Old: vcpus_old, New: vcpus_new})
 		}
 
-		if request.HasSite() || request.HasMemory() || request.HasVcpus() {
-			Debug("Payload: %+v", request)
+		if platform != nil && platform.Id != object.Platform.Get().GetId() {
+			platform_old := object.Platform.Get().GetName()
+			log.Info("Field changed", "field", "platform", "old", platform_old, "new", platform.Name)
+			request.Platform = *netbox.NewNullableBriefPlatformRequest(netbox.NewBriefPlatformRequest(platform.Name, platform.Slug))
+			recordChange(host, change{Object: "virtualization.virtualmachine", ID: object.Id, Action: "update", Field: "platform", Old: platform_old, New: platform.Name})
+		}
+
+		update := updateStatus(host, config, log, "virtualization.virtualmachine", object.Id, string(object.Status.GetValue()), object.Tags, object.CustomFields)
+		if update.Status != "" {
+			status, err := netbox.NewInventoryItemStatusValueFromValue(update.Status)
+			handleError("Validation of new status value", err)
+			request.Status = status
+		}
+		request.Tags = update.Tags
+		request.CustomFields = update.CustomFields
+
+		if request.HasSite() || request.HasMemory() || request.HasVcpus() || request.HasStatus() || request.HasCustomFields() || request.HasPlatform() || request.HasTags() {
+			log.Debug("Payload", "payload", request)
 
 			if dryRun {
-				Info("Would patch object")
-				return
+				log.Info("Would patch object")
+				vmobjid = object.Id
+			} else {
+				created, response, rerr := nb.VirtualizationAPI.VirtualizationVirtualMachinesPartialUpdate(ctx, object.Id).PatchedWritableVirtualMachineWithConfigContextRequest(request).Execute()
+				handleResponse(log, created, response, rerr)
+				vmobjid = created.Id
 			}
 
-			created, response, rerr := nb.VirtualizationAPI.VirtualizationVirtualMachinesPartialUpdate(ctx, object.Id).PatchedWritableVirtualMachineWithConfigContextRequest(request).Execute()
-			handleResponse(created, response, rerr)
-			vmobjid = created.Id
-
 		} else {
 			vmobjid = object.Id
 		}
 
 	default:
-		Error("Host %s matches multiple (%d) objects in NetBox.", name, foundcount)
+		log.Error("Host matches multiple objects in NetBox", "count", foundcount)
+	}
+
+	if !dryRun && vmobjid > 0 {
+		addJournalEntry(host, nb, ctx, "virtualization.virtualmachine", vmobjid, config.Journal)
 	}
 
 	processVirtualMachineInterface(host, nb, ctx, name, vmobjid, dryRun)
 
 }
 
-func sync(zh *zabbixHosts, nb *netbox.APIClient, ctx context.Context, dryRun bool, limit string, config SyncConfig) {
+func sync(zh *zabbixHosts, nb *netbox.APIClient, ctx context.Context, dryRun bool, config SyncConfig) {
 	sites := getSites(nb, ctx)
+	objects := getInventoryObjects(nb, ctx, config.Inventory)
+	ensureStatusTag(nb, ctx, dryRun, config)
 
 	for _, host := range *zh {
-		name := host.HostName
+		if shutdownRequested.Load() {
+			logger.Info("Shutdown requested, not processing remaining hosts")
+			return
+		}
 
-		if limit != "" && name != limit {
+		if !host.Scanned {
 			continue
 		}
 
-		if host.Error {
-			Debug("Skipping processing of host %s.", host.HostName)
+		if host.Error && hasOnlyItemErrors(host) {
+			processStaleHost(host, nb, withAuditHost(ctx, host), dryRun, config)
 			continue
 		}
 
-		sitemeta := processSite(name, sites)
+		if host.Error || host.Skipped {
+			host.log.Debug("Skipping processing of host")
+			continue
+		}
+
+		sitemeta := processSite(host, sites, config)
 
 		if sitemeta == nil {
-			Debug("Skipping processing of host %s due to unknown site.", host.HostName)
+			host.log.Debug("Skipping processing of host due to unknown site")
+			host.Reasons = append(host.Reasons, "unknown_site")
 			continue
 		}
 
-		Info("Processing host %s", name)
+		host.log.Info("Processing host", "type", host.ObjType)
+		host.Processed = true
+		host.Site = sitemeta.Slug
+
+		hostctx := withAuditHost(ctx, host)
 
 		switch host.ObjType {
 
 		case "Virtual":
-			processVirtualMachine(host, nb, ctx, dryRun, *sitemeta)
+			processVirtualMachine(host, nb, hostctx, dryRun, config, *sitemeta, objects)
 
 		case "Physical":
-			processDevice(host, nb, ctx, dryRun, config, *sitemeta)
+			processDevice(host, nb, hostctx, dryRun, config, *sitemeta, objects)
 		}
 	}
 }
@@ -23,6 +23,8 @@ import (
 	"github.com/seancfoley/ipaddress-go/ipaddr"
 	"log/slog"
 	"os"
+	"path"
+	"strings"
 )
 
 func convertLogLevel(levelStr string) slog.Level {
@@ -40,6 +42,19 @@ func convertLogLevel(levelStr string) slog.Level {
 	}
 }
 
+func newLogger(format string, level slog.Level) (*slog.Logger, bool) {
+	options := &slog.HandlerOptions{Level: level}
+
+	switch format {
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, options)), true
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, options)), true
+	}
+
+	return slog.New(slog.NewJSONHandler(os.Stderr, options)), false
+}
+
 func Debug(format string, args ...any) {
 	logger.Debug(fmt.Sprintf(format, args...))
 }
@@ -57,13 +72,23 @@ func Error(format string, args ...any) {
 }
 
 func Fatal(format string, args ...any) {
+	fatal(nil, format, args...)
+}
+
+// cause is the error of a failed request, nil if a check failed
+func fatal(cause error, format string, args ...any) {
 	Error(format, args...)
+
+	if fatalPanics.Load() {
+		panic(fatalError{message: fmt.Sprintf(format, args...), cause: cause})
+	}
+
 	os.Exit(1)
 }
 
 func handleError(action string, err error) {
 	if err != nil {
-		Fatal("%s failed: %s", action, err)
+		fatal(err, "%s failed: %s", action, err)
 	}
 }
 
@@ -77,6 +102,18 @@ func contains(values []string, value string) bool {
 	return false
 }
 
+// case insensitive shell pattern match, invalid patterns never match
+func matchPattern(pattern string, value string) bool {
+	match, err := path.Match(strings.ToLower(pattern), strings.ToLower(value))
+	if err != nil {
+		Warn("Invalid pattern '%s': %s", pattern, err)
+
+		return false
+	}
+
+	return match
+}
+
 func isLinkLocal(address string) bool {
 	ip := ipaddr.NewIPAddressString(address).GetAddress()
 	if ip == nil {
@@ -19,11 +19,18 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/fabiang/go-zabbix"
 	"gopkg.in/yaml.v3"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type zabbixMetric struct {
@@ -32,6 +39,8 @@ type zabbixMetric struct {
 	Name  string
 	Value string
 	Error string
+	// Unix time of the last received value
+	LastClock int
 }
 
 type linuxInterface struct {
@@ -44,63 +53,354 @@ type linuxInterfaces map[string]*linuxInterface
 type zabbixHostMetaData map[string]string
 
 type zabbixHostData struct {
-	HostID       string
-	HostName     string
-	Metrics      []zabbixMetric
-	Error        bool
-	ObjType      string
-	Meta         zabbixHostMetaData
-	Label        string
-	Interfaces   ipRoute2Interfaces
-	CPUs         float64
-	Memory       int32
-	Serial       string
-	Manufacturer string
-	Model        string
+	HostID         string
+	HostName       string
+	Metrics        []zabbixMetric
+	Error          bool
+	ObjType        string
+	Meta           zabbixHostMetaData
+	Label          string
+	Interfaces     ipRoute2Interfaces
+	CPUs           float64
+	Memory         int32
+	Serial         string
+	Manufacturer   string
+	Model          string
+	Virtualization string
+	Changes        []change
+	// in scope of the current run, as opposed to hosts not matching the host selection
+	Scanned bool
+	// short identifiers of the problems causing the host to be skipped
+	Reasons []string
+	// set once the host is processed in sync()
+	Processed bool
+	Site      string
+	// time of the most recent value of any item
+	LastSeen time.Time
+	// state of the host in Zabbix
+	Disabled    bool
+	Maintenance bool
+	// skipped before querying items, for example due to the policy for its Zabbix state
+	Skipped bool
+	// type of the interface providing the host name: agent or snmp
+	Interface string
+	// address of the IPMI interface
+	OobAddress string
+	// IP address of the interface providing the host name
+	PrimaryAddress string
+	// the DNS field of the interface providing the host name is empty, the name is looked up once the host is selected
+	ResolveName bool
+	// all agent, SNMP, IPMI and JMX interfaces of the host in Zabbix
+	HostInterfaces []zabbixInterface
+	Inventory      zabbix.HostInventory
+	// values of the user macros of the host, including the ones inherited from templates
+	Macros   map[string]string
+	OS       string
+	Location string
+	AssetTag string
+	// carries the host name and ID as attributes
+	log *slog.Logger
+}
+
+func (host *zabbixHostData) setLogger() {
+	host.log = logger.With("host", host.HostName, "hostid", host.HostID)
 }
 
 type zabbixHosts map[string]*zabbixHostData
 
-func zConnect(baseUrl string, user string, pass string) *zabbix.Session {
+// all Zabbix API calls are POST requests, hence the JSON-RPC method needs to be inspected
+func isIdempotentZabbixRequest(request *http.Request, body []byte) bool {
+	var rpc struct {
+		Method string `json:"method"`
+	}
+
+	if err := json.Unmarshal(body, &rpc); err != nil {
+		return false
+	}
+
+	return strings.HasSuffix(rpc.Method, ".get") || rpc.Method == "apiinfo.version"
+}
+
+// hands a session to zabbix.ClientBuilder without calling user.login
+// the builder is the only way to use a custom HTTP client with a session
+type zabbixTokenSession struct {
+	session *zabbix.Session
+}
+
+func (c *zabbixTokenSession) SetSessionLifetime(d time.Duration) {}
+
+func (c *zabbixTokenSession) SaveSession(session *zabbix.Session) error {
+	return nil
+}
+
+func (c *zabbixTokenSession) HasSession() bool {
+	return true
+}
+
+func (c *zabbixTokenSession) GetSession() (*zabbix.Session, error) {
+	return c.session, nil
+}
+
+func (c *zabbixTokenSession) Flush() error {
+	return nil
+}
+
+func zConnect(baseUrl string, user string, pass string, token string, client *http.Client) *zabbix.Session {
 	url := fmt.Sprintf("%s/api_jsonrpc.php", baseUrl)
 
-	Debug("Connecting to Zabbix at %s", url)
+	builder := zabbix.CreateClient(url).WithHTTPClient(client)
+
+	if token != "" {
+		logger.Debug("Connecting to Zabbix using an API token", "url", url)
 
-	z, err := zabbix.NewSession(url, user, pass)
+		// API tokens are used the same way as session IDs, hence no user.login call is needed
+		builder.WithCache(&zabbixTokenSession{
+			session: &zabbix.Session{URL: url, Token: token},
+		})
+	} else {
+		logger.Debug("Connecting to Zabbix using credentials", "url", url, "user", user)
+
+		builder.WithCredentials(user, pass)
+	}
+
+	z, err := builder.Connect()
+	handleError("Connection to Zabbix", err)
+
+	_, err = z.GetVersion()
 	handleError("Connection to Zabbix", err)
 
+	if token != "" {
+		// apiinfo.version does not require authentication, a wrong token would otherwise only be noticed by later queries
+		var hosts []zabbix.Host
+		err = z.Get("host.get", zabbix.HostGetParams{
+			GetParameters: zabbix.GetParameters{
+				OutputFields: []string{"hostid"},
+				ResultLimit:  1,
+			},
+		}, &hosts)
+		if err != nil {
+			Fatal("Invalid Zabbix API token: %s", err)
+		}
+	}
+
 	return z
 }
 
+func zLogout(z *zabbix.Session) {
+	logger.Debug("Logging out of Zabbix")
+
+	_, err := z.Do(zabbix.NewRequest("user.logout", []string{}), false)
+	if err != nil {
+		logger.Warn("Logout from Zabbix failed", "error", err)
+	}
+}
+
 func getHostGroups(z *zabbix.Session) []zabbix.Hostgroup {
 	hostGroups, err := z.GetHostgroups(zabbix.HostgroupGetParams{})
 	handleError("Querying host groups", err)
 
-	Debug("All host groups: %v", hostGroups)
+	logger.Debug("Queried host groups", "hostgroups", hostGroups)
 
 	return hostGroups
 }
 
-func filterHostGroupIds(hostGroups []zabbix.Hostgroup, whitelist []string) []string {
-	hostGroupIds := make([]string, 0, len(whitelist))
+// compiles the patterns prefixed with "re:" once, keyed by the pattern
+func compileHostGroupExpressions(patterns []string) (map[string]*regexp.Regexp, error) {
+	expressions := make(map[string]*regexp.Regexp)
+
+	for _, pattern := range patterns {
+		if expression, ok := strings.CutPrefix(pattern, "re:"); ok {
+			compiled, err := regexp.Compile(expression)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid host group expression '%s': %s", pattern, err)
+			}
+
+			expressions[pattern] = compiled
+		}
+	}
+
+	return expressions, nil
+}
+
+// patterns are exact names, case insensitive shell patterns or regular expressions prefixed with "re:"
+func matchHostGroup(pattern string, name string, expressions map[string]*regexp.Regexp) bool {
+	if pattern == name {
+		return true
+	}
+
+	if expression, ok := expressions[pattern]; ok {
+		return expression.MatchString(name)
+	}
+
+	return matchPattern(pattern, name)
+}
+
+// with subgroups, a group also matches if one of its parents in the slash separated hierarchy matches
+func matchHostGroupPatterns(patterns []string, name string, subgroups bool, expressions map[string]*regexp.Regexp) bool {
+	for _, pattern := range patterns {
+		if matchHostGroup(pattern, name, expressions) {
+			return true
+		}
+
+		if !subgroups {
+			continue
+		}
+
+		for i := range len(name) {
+			if name[i] == '/' && matchHostGroup(pattern, name[:i], expressions) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func filterHostGroupIds(hostGroups []zabbix.Hostgroup, patterns []string, subgroups bool, expressions map[string]*regexp.Regexp, kind string) []string {
+	hostGroupIds := make([]string, 0, len(patterns))
+	hostGroupNames := make([]string, 0, len(patterns))
+
+	if len(patterns) == 0 {
+		return hostGroupIds
+	}
+
 	for _, hg := range hostGroups {
-		if contains(whitelist, hg.Name) {
+		if matchHostGroupPatterns(patterns, hg.Name, subgroups, expressions) {
 			hostGroupIds = append(hostGroupIds, hg.GroupID)
+			hostGroupNames = append(hostGroupNames, hg.Name)
 		}
 	}
 
-	Debug("Filtered host group IDs: %v", hostGroupIds)
+	slices.Sort(hostGroupNames)
+	logger.Info(kind+" host groups", "hostgroups", hostGroupNames, "hostgroupids", hostGroupIds)
 
 	return hostGroupIds
 }
 
-func getHosts(z *zabbix.Session, groupIds []string) []zabbix.Host {
-	workHosts, err := z.GetHosts(zabbix.HostGetParams{
+// operator values of the tag filter in host.get
+var tagOperators = map[string]int{
+	"contains":   0,
+	"equals":     1,
+	"exists":     4,
+	"not_exists": 5,
+}
+
+type zabbixTagFilter struct {
+	Tag      string `json:"tag"`
+	Value    string `json:"value"`
+	Operator int    `json:"operator,string"`
+}
+
+// zabbix.HostGetParams does not support filtering by tags
+type zabbixHostGetParams struct {
+	zabbix.HostGetParams
+	Tags []zabbixTagFilter `json:"tags,omitempty"`
+	// 0 combines conditions of different tags with AND and of the same tag with OR, 2 combines all conditions with OR
+	EvalType int `json:"evaltype,string"`
+}
+
+func queryHosts(z *zabbix.Session, params zabbixHostGetParams, conditions []TagCondition) ([]zabbix.Host, error) {
+	for _, condition := range conditions {
+		params.Tags = append(params.Tags, zabbixTagFilter{
+			Tag:      condition.Tag,
+			Value:    condition.Value,
+			Operator: tagOperators[condition.Operator],
+		})
+	}
+
+	hosts := make([]zabbix.Host, 0)
+	err := z.Get("host.get", params, &hosts)
+	if err == nil && len(hosts) == 0 {
+		err = zabbix.ErrNotFound
+	}
+
+	return hosts, err
+}
+
+// the webhook reports this as a request for an unknown host rather than as a failure
+var errNoHosts = errors.New("No hosts found matching the host selection.")
+
+func getHosts(z *zabbix.Session, groupIds []string, hostIds []string, tags []TagCondition) []zabbix.Host {
+	workHosts, err := queryHosts(z, zabbixHostGetParams{
+		HostGetParams: zabbix.HostGetParams{
+			GroupIDs:        groupIds,
+			HostIDs:         hostIds,
+			SelectInventory: inventorySelect(),
+		},
+	}, tags)
+	if err == zabbix.ErrNotFound {
+		// an empty host list would cause subsequent queries to not filter by host at all
+		fatal(errNoHosts, "%s", errNoHosts)
+	}
+	handleError("Querying hosts", err)
+
+	return workHosts
+}
+
+// returns the hosts in the given groups, or none if no groups are given
+func getHostsInGroups(z *zabbix.Session, groupIds []string, hostIds []string) []zabbix.Host {
+	if len(groupIds) == 0 {
+		return nil
+	}
+
+	hosts, err := z.GetHosts(zabbix.HostGetParams{
 		GroupIDs: groupIds,
+		HostIDs:  hostIds,
 	})
+	if err == zabbix.ErrNotFound {
+		return nil
+	}
 	handleError("Querying hosts", err)
 
-	return workHosts
+	return hosts
+}
+
+// returns the hosts matching any of the given tag conditions, or none if no conditions are given
+func getHostsWithTags(z *zabbix.Session, tags []TagCondition, hostIds []string) []zabbix.Host {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	hosts, err := queryHosts(z, zabbixHostGetParams{
+		HostGetParams: zabbix.HostGetParams{
+			HostIDs: hostIds,
+		},
+		EvalType: 2,
+	}, tags)
+	if err == zabbix.ErrNotFound {
+		return nil
+	}
+	handleError("Querying hosts", err)
+
+	return hosts
+}
+
+func excludeHosts(hosts []zabbix.Host, excluded []zabbix.Host) []zabbix.Host {
+	if len(excluded) == 0 {
+		return hosts
+	}
+
+	excludedIds := make([]string, 0, len(excluded))
+	for _, h := range excluded {
+		excludedIds = append(excludedIds, h.HostID)
+	}
+
+	remaining := make([]zabbix.Host, 0, len(hosts))
+	for _, h := range hosts {
+		if contains(excludedIds, h.HostID) {
+			logger.Debug("Excluding host", "host", h.Hostname, "hostid", h.HostID)
+			continue
+		}
+
+		remaining = append(remaining, h)
+	}
+
+	if len(remaining) == 0 {
+		// an empty host list would cause subsequent queries to not filter by host at all
+		fatal(errNoHosts, "%s", errNoHosts)
+	}
+
+	return remaining
 }
 
 func filterHostIds(hosts []zabbix.Host) []string {
@@ -109,7 +409,7 @@ func filterHostIds(hosts []zabbix.Host) []string {
 		hostIds = append(hostIds, h.HostID)
 	}
 
-	Debug("Filtered host IDs: %v", hostIds)
+	logger.Debug("Filtered hosts", "hostids", hostIds)
 
 	return hostIds
 }
@@ -123,29 +423,161 @@ func getHostInterfaces(z *zabbix.Session, hostIds []string) []zabbix.HostInterfa
 	return hostInterfaces
 }
 
-func filterHostInterfaces(zh *zabbixHosts, interfaces []zabbix.HostInterface) []zabbix.HostInterface {
+var interfaceTypes = map[int]string{1: "agent", 2: "snmp", 3: "ipmi", 4: "jmx"}
+
+// interface types providing the host name, in order of preference, JMX interfaces provide no inventory data to sync
+var primaryInterfaceTypes = []int{1, 2}
+
+type zabbixInterface struct {
+	ID   string
+	Type string
+	Main bool
+	DNS  string
+	IP   string
+}
+
+func hasDNSName(iface zabbix.HostInterface) bool {
+	return iface.DNS != "" && iface.DNS != "localhost"
+}
+
+// returns the interface of the given type, preferring the default one
+func findHostInterface(interfaces []zabbix.HostInterface, ifaceType int) *zabbix.HostInterface {
+	var found *zabbix.HostInterface
+
+	for i, iface := range interfaces {
+		if iface.Type != ifaceType {
+			continue
+		}
+
+		if found == nil || (iface.Main && !found.Main) {
+			found = &interfaces[i]
+		}
+	}
+
+	return found
+}
+
+// returns the interface providing the host name, preferring the default one unless it lacks a DNS name
+func primaryInterface(interfaces []zabbix.HostInterface) *zabbix.HostInterface {
+	for _, ifaceType := range primaryInterfaceTypes {
+		main := findHostInterface(interfaces, ifaceType)
+		if main == nil {
+			continue
+		}
+
+		if hasDNSName(*main) {
+			return main
+		}
+
+		for i, iface := range interfaces {
+			if iface.Type == ifaceType && hasDNSName(iface) {
+				logger.Warn("Empty DNS field in default host interface, using another interface", "hostid", iface.HostID, "interfaceid", main.InterfaceID, "using_interfaceid", iface.InterfaceID)
+
+				return &interfaces[i]
+			}
+		}
+
+		return main
+	}
+
+	return nil
+}
+
+func filterHostInterfaces(zh *zabbixHosts, interfaces []zabbix.HostInterface, dnsConfig ReverseDNSConfig) []zabbix.HostInterface {
 	var hostInterfaces []zabbix.HostInterface
+	byHost := make(map[string][]zabbix.HostInterface)
+
 	for _, iface := range interfaces {
-		if iface.Type == 1 {
+		if _, ok := interfaceTypes[iface.Type]; ok {
 			hostInterfaces = append(hostInterfaces, iface)
-			hostname := iface.DNS
-			error := false
-			if hostname == "" || hostname == "localhost" {
-				Error("Empty DNS field in interface %s on host %s (%s)", iface.InterfaceID, iface.HostID, iface.IP)
-				hostname = iface.IP
-				error = true
+			byHost[iface.HostID] = append(byHost[iface.HostID], iface)
+		}
+	}
+
+	for hostId, ifaces := range byHost {
+		var all []zabbixInterface
+		for _, iface := range ifaces {
+			all = append(all, zabbixInterface{
+				ID:   iface.InterfaceID,
+				Type: interfaceTypes[iface.Type],
+				Main: bool(iface.Main),
+				DNS:  iface.DNS,
+				IP:   iface.IP,
+			})
+		}
+
+		primary := primaryInterface(ifaces)
 
+		if primary == nil {
+			// a BMC monitored on its own cannot be matched to the device it belongs to
+			hostname := ifaces[0].DNS
+			if hostname == "" {
+				hostname = ifaces[0].IP
 			}
 
-			(*zh)[iface.HostID] = &zabbixHostData{
-				HostID:   iface.HostID,
+			reason := "ipmi_only"
+			if findHostInterface(ifaces, 4) != nil {
+				reason = "jmx_only"
+			}
+
+			host := &zabbixHostData{
+				HostID:   hostId,
 				HostName: hostname,
-				Error:    error,
+				Skipped:  true,
+				Reasons:  []string{reason},
+				// for matching host selectors
+				HostInterfaces: all,
+			}
+			host.setLogger()
+			host.log.Warn("Host has no agent or SNMP interface", "reason", reason)
+
+			(*zh)[hostId] = host
+
+			continue
+		}
+
+		hostname := primary.DNS
+		error := false
+		resolve := false
+		if !hasDNSName(*primary) {
+			hostname = primary.IP
+
+			if dnsConfig.Enabled && primary.IP != "" {
+				// looked up in resolveHostNames() if the host is selected
+				resolve = true
+			} else {
+				logger.Error("Empty DNS field in host interface", "hostid", hostId, "interfaceid", primary.InterfaceID, "ip", primary.IP)
+				error = true
+			}
+		}
+
+		host := &zabbixHostData{
+			HostID:         hostId,
+			HostName:       hostname,
+			Error:          error,
+			Interface:      interfaceTypes[primary.Type],
+			PrimaryAddress: primary.IP,
+			ResolveName:    resolve,
+			HostInterfaces: all,
+		}
+		host.setLogger()
+
+		if error {
+			host.Reasons = append(host.Reasons, "empty_dns")
+		}
+
+		if ipmi := findHostInterface(ifaces, 3); ipmi != nil {
+			if ipmi.IP != "" {
+				host.OobAddress = ipmi.IP
+			} else {
+				host.log.Warn("IPMI interface has no IP address", "interfaceid", ipmi.InterfaceID, "dns", ipmi.DNS)
 			}
 		}
+
+		(*zh)[hostId] = host
 	}
 
-	Debug("Filtered host interfaces: %v", hostInterfaces)
+	logger.Debug("Filtered host interfaces", "interfaces", hostInterfaces)
 
 	return hostInterfaces
 }
@@ -161,12 +593,12 @@ func getItems(z *zabbix.Session, hostIds []string, search map[string][]string) [
 	})
 	handleError("Querying items", err)
 
-	Debug("Items: %v", items)
+	logger.Debug("Queried items", "items", items)
 
 	return items
 }
 
-func filterItems(zh *zabbixHosts, items []zabbix.Item, keys []string) {
+func filterItems(zh *zabbixHosts, items []zabbix.Item, keys []string, config SyncConfig) {
 	for _, item := range items {
 		//if !contains(keys, item.ItemKey) {
 		//	Debug("Discarding item with key %s", item.ItemKey)
@@ -185,17 +617,44 @@ func filterItems(zh *zabbixHosts, items []zabbix.Item, keys []string) {
 			continue
 		}
 
-		host.Metrics = append(host.Metrics, zabbixMetric{
-			ID:    item.ItemID,
-			Key:   item.ItemKey,
-			Name:  item.ItemName,
-			Value: item.LastValue,
-			Error: item.Error,
-		})
+		metric := zabbixMetric{
+			ID:        item.ItemID,
+			Key:       item.ItemKey,
+			Name:      item.ItemName,
+			Value:     item.LastValue,
+			Error:     item.Error,
+			LastClock: item.LastClock,
+		}
+
+		if item.LastClock > 0 && item.Error == "" {
+			lastClock := time.Unix(int64(item.LastClock), 0)
+			if lastClock.After(host.LastSeen) {
+				host.LastSeen = lastClock
+			}
+		}
+
+		// stale items are treated as missing
+		if isStaleItem(metric, config) {
+			host.log.Warn("Item value is too old", "itemid", item.ItemID, "item", item.ItemKey, "lastclock", time.Unix(int64(item.LastClock), 0), "max_age", itemMaxAge(item.ItemKey, config).String())
+
+			if config.StaleItems == "skip" {
+				host.Error = true
+				if !contains(host.Reasons, "stale_items") {
+					host.Reasons = append(host.Reasons, "stale_items")
+				}
+			}
+
+			continue
+		}
+
+		host.Metrics = append(host.Metrics, metric)
 
 		if item.Error != "" {
 			host.Error = true
-			Error("Item %s (%s) in host %s contains error: '%s'", item.ItemID, item.ItemName, item.HostID, item.Error)
+			if !contains(host.Reasons, "item_error") {
+				host.Reasons = append(host.Reasons, "item_error")
+			}
+			host.log.Error("Item contains error", "itemid", item.ItemID, "item", item.ItemKey, "error", item.Error)
 		}
 	}
 }
@@ -205,7 +664,6 @@ func parseHostMetadata(raw string) (zabbixHostMetaData, bool, error) {
 	metadata := make(zabbixHostMetaData)
 
 	err := yaml.Unmarshal([]byte(raw), &metadata)
-	Debug("parseHostMetadata() unmarshalled %v", metadata)
 	if err != nil {
 		return nil, ok, err
 	}
@@ -221,7 +679,7 @@ func scanHostMetadata(host *zabbixHostData) {
 	for k, v := range host.Meta {
 		if k == "label" {
 			host.Label = v
-			Debug("scanHostMetadata() set label of host %s (%s) to %s", host.HostID, host.HostName, host.Label)
+			host.log.Debug("Set label from metadata", "label", host.Label)
 
 			return
 		}
@@ -233,14 +691,14 @@ func scanHostMetadata(host *zabbixHostData) {
 	return
 }
 
-func scanHost(host *zabbixHostData) bool {
+func scanHost(host *zabbixHostData, config SyncConfig) bool {
 	have_agent_hostname := false
 	have_sys_hw_metadata := false
 
 	host.Interfaces = ipRoute2Interfaces{}
 
 	for _, metric := range host.Metrics {
-		Debug("scanHost() processing %s => %s", metric.Key, metric.Value)
+		host.log.Debug("Processing item", "itemid", metric.ID, "item", metric.Key, "value", metric.Value)
 
 		mkey := metric.Key
 
@@ -253,15 +711,22 @@ func scanHost(host *zabbixHostData) bool {
 			continue
 		}
 
+		if config.VirtualizationItem != "" && mkey == config.VirtualizationItem {
+			host.Virtualization = strings.TrimSpace(metric.Value)
+
+			continue
+		}
+
 		switch mkey {
 
 		case "agent.hostname":
 			have_agent_hostname = true
 
 			if host.HostName != metric.Value {
-				Warn("Host %s serves ambiguous names (DNS name '%s' vs Host name '%s').", host.HostID, host.HostName, metric.Value)
+				host.log.Warn("Host serves ambiguous names", "dns_name", host.HostName, "agent_hostname", metric.Value)
 				if metric.Value != "" {
 					host.HostName = metric.Value
+					host.setLogger()
 				}
 			}
 
@@ -270,51 +735,44 @@ func scanHost(host *zabbixHostData) bool {
 				if host.Serial == "" {
 					host.Serial = metric.Value
 				} else if host.Serial != metric.Value {
-					Warn("Host %s (%s) serves ambiguous serial numbers.", host.HostID, host.HostName)
+					host.log.Warn("Host serves ambiguous serial numbers", "serial", host.Serial, "item", mkey, "value", metric.Value)
 				}
 			}
-			Debug("Got host %s serial %s", host.HostName, metric.Value)
 
 		case "sys.hw.manufacturer":
 			host.Manufacturer = metric.Value
 
-			if metric.Value == "QEMU" || metric.Value == "Bochs" {
-				host.ObjType = "Virtual"
-				// TODO: map virtualization cluster
-			} else {
-				// assuming all non-QEMU values to be physical is not ideal
-				// is there a better item than sys.hw.manufacturer for this ?
-				host.ObjType = "Physical"
-			}
-
 		case "sys.hw.metadata":
 			have_sys_hw_metadata = true
 
 			metadata, ok, err := parseHostMetadata(metric.Value)
 			if err == nil {
 				if !ok {
-					Warn("Host %s (%s) serves empty metadata", host.HostID, host.HostName)
+					host.log.Warn("Host serves empty metadata", "item", mkey)
 					break
 				}
 
 				host.Meta = metadata
-				scanHostMetadata(host)
 
 				break
 			}
 
-			Error("Host %s (%s) serves invalid metadata: %s", host.HostID, host.HostName, err)
+			host.log.Error("Host serves invalid metadata", "item", mkey, "error", err)
 			host.Error = true
+			host.Reasons = append(host.Reasons, "invalid_metadata")
 
 		case "sys.hw.model":
 			host.Model = metric.Value
 
+		case "sys.os.release":
+			host.OS = strings.TrimSpace(metric.Value)
+
 		case "system.cpu.num":
 			cpus, err := strconv.ParseFloat(metric.Value, 64)
 			if err == nil {
 				host.CPUs = cpus
 			} else {
-				Error("Host %s (%s) serves invalid value for 'sys.cpu.count': %s - conversion to float failed: %s", host.HostID, host.HostName, metric.Value, err)
+				host.log.Error("Host serves invalid number of CPUs", "item", mkey, "value", metric.Value, "error", err)
 			}
 
 		case "vm.memory.size[total]":
@@ -323,31 +781,46 @@ func scanHost(host *zabbixHostData) bool {
 				// for NB, memory needs to be in Megabytes
 				// should there be some check whether the calculated MB value actually fits into 32 bit?
 				memory_mb := int32(float64(memory_b) / (1 << 20))
-				Debug("Converted memory %d to %d", memory_b, memory_mb)
+				host.log.Debug("Converted memory to megabytes", "bytes", memory_b, "megabytes", memory_mb)
 				host.Memory = memory_mb
 			} else {
-				Error("Host %s (%s) serves invalid value for 'vm.memory.size': %s - conversion to integer failed: %s", host.HostID, host.HostName, metric.Value, err)
+				host.log.Error("Host serves invalid memory size", "item", mkey, "value", metric.Value, "error", err)
 			}
 
 		}
 	}
 
-	Debug("Got host %s interface %v", host.HostName, host.Interfaces)
+	host.log.Debug("Parsed interfaces", "interfaces", host.Interfaces)
+
+	applyInventory(host, config.Inventory)
+	mergeMacros(host, config.Macros)
+
+	if len(host.Meta) > 0 {
+		scanHostMetadata(host)
+	}
+
+	// metadata can override the classification, hence this needs to happen after all items were processed
+	// TODO: map virtualization cluster
+	host.ObjType = classifyHost(host, config.Classification)
+	host.log.Debug("Classified host", "type", host.ObjType)
 
 	if !have_agent_hostname {
-		Error("Host %s (%s) is missing the 'agent.hostname' item.", host.HostID, host.HostName)
+		host.log.Error("Host is missing an item", "item", "agent.hostname")
+		host.Reasons = append(host.Reasons, "missing_agent_hostname")
 	}
 
 	if host.Manufacturer == "" {
-		Error("Host %s (%s) is missing the 'sys.hw.manufacturer' item.", host.HostID, host.HostName)
+		host.log.Error("Host is missing a manufacturer", missingValueSources(config.Inventory, "manufacturer", "sys.hw.manufacturer")...)
+		host.Reasons = append(host.Reasons, "missing_manufacturer")
 	}
 
 	if !have_sys_hw_metadata {
-		Warn("Host %s (%s) is missing the 'sys.hw.metadata' item.", host.HostID, host.HostName)
+		host.log.Warn("Host is missing an item", "item", "sys.hw.metadata")
 	}
 
 	if host.ObjType == "Physical" && host.Serial == "" {
-		Warn("Host %s (%s) is missing a serial number.", host.HostID, host.HostName)
+		host.log.Warn("Host is missing a serial number", missingValueSources(config.Inventory, "serial", "sys.hw.chassis_serial", "sys.hw.product_serial")...)
+		host.Reasons = append(host.Reasons, "missing_serial")
 	}
 
 	if !have_agent_hostname || host.Manufacturer == "" || (host.ObjType == "Physical" && host.Serial == "") {
@@ -359,26 +832,29 @@ func scanHost(host *zabbixHostData) bool {
 	return true
 }
 
-func scanHosts(zh *zabbixHosts, limit string) {
+func scanHosts(zh *zabbixHosts, config SyncConfig) {
 	for _, host := range *zh {
-		name := host.HostName
-
-		if limit != "" && name != limit {
+		if !host.Scanned {
 			continue
 		}
 
-		if host.Error {
-			Debug("Skipping preprocessing of host %s.", name)
+		if host.Error || host.Skipped {
+			host.log.Debug("Skipping preprocessing of host")
 
 			continue
 		}
 
-		Debug("Preprocessing host %s", name)
+		host.log.Debug("Preprocessing host")
 
-		ok := scanHost(host)
+		var ok bool
+		if host.Interface == "snmp" {
+			ok = scanSNMPHost(host, config)
+		} else {
+			ok = scanHost(host, config)
+		}
 
 		if !ok {
-			Debug("Scan of host %s (%s) returned errors.", host.HostID, name)
+			host.log.Debug("Scan of host returned errors", "reasons", host.Reasons)
 
 			continue
 		}
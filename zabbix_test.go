@@ -0,0 +1,51 @@
+/*
+   Zabbix -> NetBox synchronization tool
+   Copyright (C) 2025  SUSE LLC <georg.pfuetzenreuter@suse.com>
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+func TestMatchHostGroupPatternsNested(t *testing.T) {
+	setupTestLogger()
+
+	patterns := []string{"Corporate/*", "re:^Lab/.*/Servers$"}
+
+	expressions, err := compileHostGroupExpressions(patterns)
+	if err != nil {
+		t.Fatalf("Compiling expressions failed: %s", err)
+	}
+
+	for _, test := range []struct {
+		name      string
+		subgroups bool
+		expected  bool
+	}{
+		{"Corporate/Team", false, true},
+		{"Corporate/Team/Sub", false, false},
+		{"Corporate/Team/Sub", true, true},
+		{"Corporate", true, false},
+		{"Lab/Team/Sub/Servers", false, true},
+		{"Other/Team", true, false},
+	} {
+		if match := matchHostGroupPatterns(patterns, test.name, test.subgroups, expressions); match != test.expected {
+			t.Errorf("Expected match %t for host group '%s' with subgroups %t, got %t", test.expected, test.name, test.subgroups, match)
+		}
+	}
+}